@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"greenlight.johnboucha.com/internal/data"
+)
+
+// own type for context keys, so they can't collide with other packages' keys
+type contextKey string
+
+const (
+	userContextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+)
+
+// returns a copy of the request with the user added to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// returns the user the authenticate middleware added to the request
+// context; it's always there, so a missing one is a bug
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+
+	return user
+}
+
+// returns a copy of the request with the user's permissions added to its
+// context, for when the credentials carried them
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// returns the permissions added to the request context, if any
+func (app *application) contextGetPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// handler for "POST /v1/movies/:id/credits"
+func (app *application) createCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForChange(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		PersonID  int64  `json:"person_id"`
+		Role      string `json:"role"`
+		Character string `json:"character"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:   movie.ID,
+		PersonID:  input.PersonID,
+		Role:      input.Role,
+		Character: input.Character,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Credits.Insert(credit, movie.Version, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.versionConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownPerson):
+			v.AddError("person_id", "must refer to an existing person")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateCredit):
+			v.AddError("person_id", "already has this credit on the movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// handler for "DELETE /v1/movies/:id/credits/:credit_id"
+func (app *application) deleteCreditHandler(w http.ResponseWriter, r *http.Request) {
+	creditID, err := app.readNamedIDParam(r, "credit_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, ok := app.movieForChange(w, r)
+	if !ok {
+		return
+	}
+
+	err = app.models.Credits.Delete(movie.ID, creditID, movie.Version, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.versionConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
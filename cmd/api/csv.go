@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// columns written by the CSV export; genres are joined with genreSeparator
+var movieCSVColumns = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+const genreSeparator = "|"
+
+// handler for "GET /v1/movies/export?format=csv"
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// same filters and sorting as listMoviesHandler, but without paging
+	filters, sort, err := app.readUnpagedMovieFilters(qs, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	format := app.readString(qs, "format", "csv")
+	v.Check(format == "csv", "format", "must be csv")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// a large export takes longer than the server's WriteTimeout, so lift
+	// it for this response; a client that goes away still cancels the context
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(movieCSVColumns)
+
+	rows := 0
+	err = app.models.Movies.ForEach(r.Context(), filters.Title, filters.Genres, filters.Ranges, filters.People, filters.Search, sort, func(movie *data.Movie) error {
+		rows++
+		return cw.Write([]string{
+			strconv.FormatInt(movie.ID, 10),
+			movie.Title,
+			strconv.Itoa(int(movie.Year)),
+			strconv.Itoa(int(movie.Runtime)),
+			strings.Join(movie.Genres, genreSeparator),
+			strconv.Itoa(int(movie.Version)),
+		})
+	})
+
+	if err != nil {
+		// nothing has been sent yet, so a proper error response is still possible
+		if rows == 0 {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// part of the file may already be sent with a 200 status, so break
+		// off the response rather than let it look like a complete file
+		if r.Context().Err() == nil {
+			app.logError(r, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.logError(r, err)
+	}
+}
+
+// handler for "POST /v1/movies/import?mode=..."; the body is a CSV file with
+// a header row naming the title, year, runtime and genres columns
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+
+	// spreadsheets are bigger than JSON bodies, so allow up to 10MB
+	maxBytes := 10_485_760
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	v := validator.New()
+
+	mode := app.readString(r.URL.Query(), "mode", "atomic")
+	if v.Check(validator.In(mode, "atomic", "best_effort"), "mode", "must be atomic or best_effort"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	cr := csv.NewReader(r.Body)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		app.badRequestResponse(w, r, csvReadError(err, maxBytes))
+		return
+	}
+
+	columns, err := movieCSVHeader(header)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var movies []*data.Movie
+	var results []bulkResult
+	var rows []int
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			app.badRequestResponse(w, r, csvReadError(err, maxBytes))
+			return
+		}
+
+		// line number as shown by a spreadsheet, header included
+		line, _ := cr.FieldPos(0)
+
+		movie, errs := parseMovieCSVRecord(record, columns)
+
+		movies = append(movies, movie)
+		results = append(results, bulkResult{Errors: errs})
+		rows = append(rows, line)
+	}
+
+	v.Check(len(movies) >= 1, "rows", "must contain at least 1 movie")
+	v.Check(len(movies) <= 5000, "rows", "must not contain more than 5000 movies")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results, status, err := app.createMovies(r, movies, results, mode == "atomic")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// outcome for each row of the file
+	type rowResult struct {
+		Row int `json:"row"`
+		bulkResult
+	}
+
+	report := make([]rowResult, len(results))
+	for i := range results {
+		report[i] = rowResult{Row: rows[i], bulkResult: results[i]}
+	}
+
+	if status == http.StatusUnprocessableEntity {
+		app.failedBulkResponse(w, r, report)
+		return
+	}
+
+	err = app.writeJSON(w, status, envelope{"results": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// maps the column names of an import's header row to their positions;
+// id and version columns, as written by the export, are ignored
+func movieCSVHeader(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		// spreadsheets often start the file with a UTF-8 byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case "title", "year", "runtime", "genres":
+			if _, exists := columns[name]; exists {
+				return nil, fmt.Errorf("header contains duplicate column %q", name)
+			}
+			columns[name] = i
+		case "id", "version":
+		default:
+			return nil, fmt.Errorf("header contains unknown column %q", name)
+		}
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("header must contain a %q column", name)
+		}
+	}
+
+	return columns, nil
+}
+
+// maps a CSV record onto a movie, returning errors for values that can't be
+// parsed; empty cells are left for ValidateMovie to report
+func parseMovieCSVRecord(record []string, columns map[string]int) (*data.Movie, map[string]string) {
+	movie := &data.Movie{}
+	errs := make(map[string]string)
+
+	movie.Title = strings.TrimSpace(record[columns["title"]])
+
+	if s := strings.TrimSpace(record[columns["year"]]); s != "" {
+		year, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			errs["year"] = "must be an integer value"
+		}
+		movie.Year = int32(year)
+	}
+
+	// runtime may be written as "<n> mins" or a plain integer
+	if s := strings.TrimSpace(record[columns["runtime"]]); s != "" {
+		runtime, err := data.ParseRuntime(s)
+		if err != nil {
+			errs["runtime"] = `must be an integer or in the form "<n> mins"`
+		}
+		movie.Runtime = runtime
+	}
+
+	if s := strings.TrimSpace(record[columns["genres"]]); s != "" {
+		movie.Genres = []string{}
+		for _, genre := range strings.Split(s, genreSeparator) {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				movie.Genres = append(movie.Genres, genre)
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return movie, nil
+	}
+
+	return movie, errs
+}
+
+// turns errors from reading an uploaded CSV file into client-facing messages
+func csvReadError(err error, maxBytes int) error {
+	var parseError *csv.ParseError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return errors.New("body must not be empty")
+	case err.Error() == "http: request body too large":
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+	case errors.As(err, &parseError):
+		return fmt.Errorf("body contains badly-formed CSV (line %d): %v", parseError.Line, parseError.Err)
+	default:
+		return err
+	}
+}
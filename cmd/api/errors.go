@@ -54,14 +54,80 @@ func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
 
+// handles 422 Unprocessable Entity for bulk requests, with the outcome of each item
+func (app *application) failedBulkResponse(w http.ResponseWriter, r *http.Request, results interface{}) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, results)
+}
+
 // handles race condition when multiple users edit same data
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+// handles deleting a record that other records still refer to
+func (app *application) recordInUseResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
 // handles the rate limit exceeded errors
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
+
+// handles If-Match headers that don't match the current version
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since it was last read, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+// handles a change that lost a race with another one after it was read; a
+// conditional request's precondition no longer holds, so it gets a 412
+func (app *application) versionConflictResponse(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("If-Match") != "" {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+	app.editConflictResponse(w, r)
+}
+
+// handles changes sent without an If-Match header when one is required
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must be made conditional with an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+// handles a login with the wrong email or password
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// handles a missing, malformed or expired bearer token
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// handles anonymous requests to endpoints that need a user
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// handles users who haven't activated their account yet
+func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// handles users without the permission an endpoint needs
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
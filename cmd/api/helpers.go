@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +13,8 @@ import (
 	"strconv"
 	"strings"
 
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/jsonpatch"
 	"greenlight.johnboucha.com/internal/validator"
 
 	"github.com/julienschmidt/httprouter"
@@ -19,14 +24,19 @@ import (
 type envelope map[string]interface{}
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// reads an ID from the URL parameter with the given name, for routes with more than one ID
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 
 	// retrieves a slice of URL parameters
 	params := httprouter.ParamsFromContext(r.Context())
 
 	// checks and returns valid ID parameter
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -119,6 +129,74 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// applies a JSON Merge Patch or JSON Patch request body, as given by mediaType,
+// to the JSON form of src and decodes the patched document into dst
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, mediaType string, src, dst interface{}) error {
+
+	// limit the request body to 1MB, same as readJSON
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	doc, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case jsonpatch.MergePatchType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	case jsonpatch.JSONPatchType:
+		patched, err = jsonpatch.Apply(doc, patch)
+	default:
+		return fmt.Errorf("unsupported patch media type %q", mediaType)
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to apply patch: %w", err)
+	}
+
+	// the patched document must still fit the target
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(dst)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("patch results in incorrect JSON type for field %q", unmarshalTypeError.Field)
+
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("patch results in unknown key %s", fieldName)
+
+		// the patch replaced the whole document with a non-object
+		case strings.HasPrefix(err.Error(), "json: cannot unmarshal"):
+			return errors.New("patch must result in a JSON object")
+
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
 // reads the key values from the query string, or returns default value if key not provided
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 
@@ -162,3 +240,147 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	return i
 }
+
+// reads a boolean from the query string, or returns default value if key not provided
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+
+	// 's' will be "" if key is not present
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// reads a runtime from the query string, either as "<n> mins" or a plain integer
+func (app *application) readRuntime(qs url.Values, key string, defaultValue data.Runtime, v *validator.Validator) data.Runtime {
+
+	// 's' will be "" if key is not present
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	runtime, err := data.ParseRuntime(s)
+	if err != nil {
+		v.AddError(key, `must be an integer or in the form "<n> mins"`)
+		return defaultValue
+	}
+
+	return runtime
+}
+
+// returns the ETag of a movie; id and version identify its content, apart
+// from the rating, which reviews change without bumping the version
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d-%d-%g"`, movie.ID, movie.Version, movie.RatingsCount, movie.AverageRating)
+}
+
+// returns the ETag of a user, so admins can make changes conditional on
+// not having missed someone else's
+func userETag(user *data.User) string {
+	return fmt.Sprintf(`"%d-%d"`, user.ID, user.Version)
+}
+
+// returns a weak ETag for a response built from several records, such as a
+// page of movies, by hashing the envelope holding their ids and versions
+func envelopeETag(env envelope) (string, error) {
+	js, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// reports whether an If-Match or If-None-Match header value lists etag;
+// weak comparison ignores the W/ prefix, strong comparison never matches weak tags
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sends 304 Not Modified if the request's If-None-Match lists etag, and
+// reports whether it did; the caller should stop handling the request if so
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// checks the request's If-Match header against etag before a change, sending
+// 412 Precondition Failed on a mismatch, or 428 Precondition Required if the
+// header is missing and preconditions are required; reports whether the
+// caller may go ahead
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+
+	if header == "" {
+		if app.config.preconditions.required {
+			app.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+
+	if !etagMatches(header, etag, false) {
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// runs fn in a background goroutine, recovering from any panic, and
+// tracks it so a graceful shutdown waits for it to finish
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// file extensions for the accepted image formats
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// handler for "POST /v1/movies/:id/images"; takes a multipart form with the
+// file in an "image" field and its kind (poster or still) in a "kind" field
+func (app *application) uploadMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	movie, ok := app.movieForChange(w, r)
+	if !ok {
+		return
+	}
+
+	// images get their own size limit instead of readJSON's, with a little
+	// extra room for the multipart framing around the file
+	maxBytes := app.config.images.maxSize + 64<<10
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	// anything over 1MB is buffered in a temporary file
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("image must not be larger than %d bytes", app.config.images.maxSize))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := validator.New()
+
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrMissingFile):
+			v.AddError("image", "must be provided")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	// trust the file's contents rather than the Content-Type the client sent
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	image := &data.Image{
+		MovieID:     movie.ID,
+		Kind:        r.FormValue("kind"),
+		ContentType: http.DetectContentType(head[:n]),
+		Size:        header.Size,
+	}
+
+	v.Check(image.Size <= app.config.images.maxSize, "image", fmt.Sprintf("must not be larger than %d bytes", app.config.images.maxSize))
+
+	if data.ValidateImage(v, image); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	image.Key, err = imageKey(movie.ID, image.ContentType)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storage.Save(r.Context(), image.Key, file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Images.Insert(image, movie.Version, app.contextGetUser(r).ID)
+	if err != nil {
+		// the file isn't attached to anything, so don't keep it
+		if err := app.storage.Delete(r.Context(), image.Key); err != nil {
+			app.logError(r, err)
+		}
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.versionConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	image.URL = app.storage.URL(image.Key)
+
+	headers := make(http.Header)
+	headers.Set("Location", image.URL)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"image": image}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// handler for "DELETE /v1/movies/:id/images/:image_id"
+func (app *application) deleteMovieImageHandler(w http.ResponseWriter, r *http.Request) {
+	imageID, err := app.readNamedIDParam(r, "image_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, ok := app.movieForChange(w, r)
+	if !ok {
+		return
+	}
+
+	image, err := app.models.Images.Delete(movie.ID, imageID, movie.Version, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.versionConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// the image is already detached, so a file that can't be removed is only logged
+	err = app.storage.Delete(r.Context(), image.Key)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "image successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loads the images of a movie, with the URLs they can be fetched from
+func (app *application) movieImages(movieID int64) ([]*data.Image, error) {
+	images, err := app.models.Images.GetAllForMovie(movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, image := range images {
+		image.URL = app.storage.URL(image.Key)
+	}
+
+	return images, nil
+}
+
+// returns a new, unguessable storage key for an image of a movie
+func imageKey(movieID int64, contentType string) (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("movies/%d/%s%s", movieID, hex.EncodeToString(b), imageExtensions[contentType]), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/jwt"
+)
+
+// jwtAuth issues and checks JWTs
+type jwtAuth struct {
+	signingKey *jwt.Key // nil unless JWTs are issued on login
+	verifier   *jwt.Verifier
+	ttl        time.Duration
+}
+
+// loads the configured JWT keys; returns nil if JWTs aren't in use at all.
+// The signing key is always accepted for verification too, and the extra
+// verification keys let tokens signed with retired keys run out during a
+// rotation, or come from other services
+func newJWTAuth(cfg config) (*jwtAuth, error) {
+	if !cfg.jwt.enabled && cfg.jwt.signingKey == "" && len(cfg.jwt.verificationKeys) == 0 {
+		return nil, nil
+	}
+
+	auth := &jwtAuth{
+		verifier: &jwt.Verifier{
+			Keys:     make(map[string]*jwt.Key),
+			Issuer:   cfg.jwt.issuer,
+			Audience: cfg.jwt.audience,
+			Leeway:   30 * time.Second,
+		},
+		ttl: cfg.jwt.ttl,
+	}
+
+	if cfg.jwt.signingKey != "" {
+		key, err := jwt.ParseKeySpec(cfg.jwt.signingKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if !key.CanSign() {
+			return nil, errors.New("jwt: signing key must be a private key or secret")
+		}
+
+		auth.verifier.Keys[key.ID] = key
+
+		if cfg.jwt.enabled {
+			auth.signingKey = key
+		}
+	}
+
+	for _, spec := range cfg.jwt.verificationKeys {
+		key, err := jwt.ParseKeySpec(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, exists := auth.verifier.Keys[key.ID]; exists {
+			return nil, errors.New("jwt: duplicate key id " + strconv.Quote(key.ID))
+		}
+
+		auth.verifier.Keys[key.ID] = key
+	}
+
+	if cfg.jwt.enabled && auth.signingKey == nil {
+		return nil, errors.New("jwt: -jwt-signing-key is required when JWTs are enabled")
+	}
+
+	return auth, nil
+}
+
+// reports whether logins get a JWT rather than a database token
+func (a *jwtAuth) issuing() bool {
+	return a != nil && a.signingKey != nil
+}
+
+// returns a signed token for the user, and when it expires
+func (a *jwtAuth) issue(user *data.User, permissions data.Permissions) (string, time.Time, error) {
+	now := time.Now()
+	expiry := now.Add(a.ttl)
+
+	claims := jwt.Claims{
+		Issuer:      a.verifier.Issuer,
+		Subject:     strconv.FormatInt(user.ID, 10),
+		Audience:    jwt.Audience{a.verifier.Audience},
+		Expiry:      expiry.Unix(),
+		NotBefore:   now.Unix(),
+		IssuedAt:    now.Unix(),
+		Activated:   user.Activated,
+		Permissions: permissions,
+	}
+
+	token, err := jwt.Sign(claims, a.signingKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiry, nil
+}
+
+// verifies a token and returns its claims, along with the id of the user
+// it was issued to
+func (a *jwtAuth) authenticate(token string) (int64, *jwt.Claims, error) {
+	claims, err := a.verifier.Verify(token, time.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return 0, nil, jwt.ErrMalformed
+	}
+
+	return id, claims, nil
+}
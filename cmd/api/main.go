@@ -5,10 +5,14 @@ import (
 	"database/sql"
 	"flag"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"greenlight.johnboucha.com/internal/data"
 	"greenlight.johnboucha.com/internal/jsonlog"
+	"greenlight.johnboucha.com/internal/mailer"
+	"greenlight.johnboucha.com/internal/storage"
 
 	_ "github.com/lib/pq"
 )
@@ -31,14 +35,52 @@ type config struct {
 		burst   int
 		enabled bool
 	}
+	trash struct {
+		retention time.Duration
+	}
+	preconditions struct {
+		required bool
+	}
+	images struct {
+		dir     string
+		baseURL string
+		maxSize int64
+	}
+	similar struct {
+		genreWeight float64
+		yearWeight  float64
+		titleWeight float64
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
+	mail struct {
+		dir string
+	}
+	jwt struct {
+		enabled          bool
+		signingKey       string
+		verificationKeys []string
+		issuer           string
+		audience         string
+		ttl              time.Duration
+	}
 }
 
 // application struct holds dependencies for HTTP handlers,
 // helpers, middleware, etc...
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	storage storage.Storage
+	mailer  mailer.Mailer
+	jwt     *jwtAuth
+	wg      sync.WaitGroup
 }
 
 func main() {
@@ -56,6 +98,37 @@ func main() {
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	// how long deleted movies stay in the trash before they can be purged
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Minimum time deleted movies are kept in the trash")
+	// reject movie updates and deletes, and user updates, that don't send If-Match
+	flag.BoolVar(&cfg.preconditions.required, "require-precondition", false, "Require If-Match on movie updates and deletes and on user updates")
+	// where uploaded movie images are stored, and the URL they're served from
+	flag.StringVar(&cfg.images.dir, "images-dir", "./uploads", "Directory uploaded images are stored in")
+	flag.StringVar(&cfg.images.baseURL, "images-base-url", "/v1/images", "Base URL uploaded images are served from")
+	flag.Int64Var(&cfg.images.maxSize, "images-max-size", 10<<20, "Maximum size of an uploaded image in bytes")
+	// how much each signal counts when scoring similar movies
+	flag.Float64Var(&cfg.similar.genreWeight, "similar-genre-weight", 0.6, "Weight of shared genres when scoring similar movies")
+	flag.Float64Var(&cfg.similar.yearWeight, "similar-year-weight", 0.25, "Weight of release year closeness when scoring similar movies")
+	flag.Float64Var(&cfg.similar.titleWeight, "similar-title-weight", 0.15, "Weight of title similarity when scoring similar movies")
+
+	// SMTP server used to send emails
+	flag.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&cfg.smtp.username, "smtp-username", os.Getenv("GREENLIGHT_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&cfg.smtp.password, "smtp-password", os.Getenv("GREENLIGHT_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.johnboucha.com>", "SMTP sender")
+	// for local development, emails can be written to files instead of sent
+	flag.StringVar(&cfg.mail.dir, "mail-dir", "", "Write emails to files in this directory instead of sending them over SMTP")
+	// stateless JWTs, as an alternative to tokens stored in the database
+	flag.BoolVar(&cfg.jwt.enabled, "jwt-enabled", false, "Issue signed JWTs instead of database tokens on login")
+	flag.StringVar(&cfg.jwt.signingKey, "jwt-signing-key", "", "Key JWTs are signed with, as kid:algorithm:path (HS256 or EdDSA)")
+	flag.Func("jwt-verification-keys", "Comma-separated extra keys JWTs are accepted from, as kid:algorithm:path", func(val string) error {
+		cfg.jwt.verificationKeys = strings.Split(val, ",")
+		return nil
+	})
+	flag.StringVar(&cfg.jwt.issuer, "jwt-issuer", "greenlight.johnboucha.com", "JWT issuer")
+	flag.StringVar(&cfg.jwt.audience, "jwt-audience", "greenlight.johnboucha.com", "JWT audience")
+	flag.DurationVar(&cfg.jwt.ttl, "jwt-ttl", 24*time.Hour, "How long issued JWTs are valid for")
 
 	flag.Parse()
 
@@ -71,9 +144,24 @@ func main() {
 	logger.PrintInfo("database connection pool established", nil)
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
+		config:  cfg,
+		logger:  logger,
+		models:  data.NewModels(db),
+		storage: &storage.Local{Dir: cfg.images.dir, BaseURL: cfg.images.baseURL},
+	}
+
+	app.mailer, err = mailer.NewSMTP(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.mail.dir != "" {
+		app.mailer = &mailer.File{Dir: cfg.mail.dir, Sender: cfg.smtp.sender}
+	}
+
+	app.jwt, err = newJWTAuth(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
 	}
 
 	// start the server, from server.go
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/validator"
 )
 
 // function that recovers from panics in our main application
@@ -16,6 +20,12 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		defer func() {
 			// checking if there has been a panic
 			if err := recover(); err != nil {
+				// handlers abort responses that are already under way on
+				// purpose; let the server close the connection
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				w.Header().Set("Connection", "close")
 				// generate a 500 error from errors.go
 				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -96,3 +106,137 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// resolves an "Authorization: Bearer <token>" header into the user it
+// belongs to and adds them to the request context; requests without the
+// header go through as the anonymous user
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response depends on who's asking
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || !strings.EqualFold(headerParts[0], "Bearer") {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		// JWTs are checked by their signature, and their user is still
+		// looked up so logging out or deactivating them takes effect
+		// straight away; anything else is looked up as a database token
+		if app.jwt != nil && strings.Count(token, ".") == 2 {
+			id, claims, err := app.jwt.authenticate(token)
+			if err != nil {
+				app.invalidAuthenticationTokenResponse(w, r)
+				return
+			}
+
+			user, err := app.models.Users.GetForSignedToken(id, time.Unix(claims.IssuedAt, 0))
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAuthenticationTokenResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			// a role change revokes the user's tokens, so the permissions
+			// in the claims are still theirs
+			r = app.contextSetUser(r, user)
+			r = app.contextSetPermissions(r, data.Permissions(claims.Permissions))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// only lets authenticated users through
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// only lets authenticated users with an activated account through
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
+// only lets activated users with the given permission through. JWTs carry
+// the user's permissions; for other tokens they're looked up
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		permissions, ok := app.contextGetPermissions(r)
+
+		if !ok {
+			var err error
+
+			permissions, err = app.models.Permissions.GetAllForUser(app.contextGetUser(r).ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
 
 	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/jsonpatch"
 	"greenlight.johnboucha.com/internal/validator"
 )
 
@@ -37,12 +40,18 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	v := validator.New()
 
 	// validate the movie
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -51,6 +60,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// create a Location for newly created movie
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
 
 	// send a JSON response with 201 Created status code, movie data, and Location
 	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
@@ -59,6 +69,201 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// handler for "POST /v1/movies/bulk?mode=..."
+func (app *application) bulkCreateMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// array of movies in the same shape createMovieHandler accepts
+	var input []struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	mode := app.readString(r.URL.Query(), "mode", "atomic")
+
+	v.Check(validator.In(mode, "atomic", "best_effort"), "mode", "must be atomic or best_effort")
+	v.Check(len(input) >= 1, "movies", "must contain at least 1 movie")
+	v.Check(len(input) <= 500, "movies", "must not contain more than 500 movies")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies := make([]*data.Movie, len(input))
+	for i, in := range input {
+		movies[i] = &data.Movie{
+			Title:   in.Title,
+			Year:    in.Year,
+			Runtime: in.Runtime,
+			Genres:  in.Genres,
+		}
+	}
+
+	results, status, err := app.createMovies(r, movies, make([]bulkResult, len(movies)), mode == "atomic")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// outcome for each movie, in the order they were sent
+	type indexedResult struct {
+		Index int `json:"index"`
+		bulkResult
+	}
+
+	indexed := make([]indexedResult, len(results))
+	for i := range results {
+		indexed[i] = indexedResult{Index: i, bulkResult: results[i]}
+	}
+
+	if status == http.StatusUnprocessableEntity {
+		app.failedBulkResponse(w, r, indexed)
+		return
+	}
+
+	err = app.writeJSON(w, status, envelope{"results": indexed}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// outcome of creating one movie of a bulk request or import
+type bulkResult struct {
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// validates and creates movies; atomic creates all of them in one transaction
+// or none if any is invalid, otherwise every valid movie is created on its own.
+// results may already hold errors found while reading the input. Returns the
+// outcome per movie and the status code to respond with: 201 if every movie
+// was created, 207 if only some were, and 422 if an atomic request was invalid
+func (app *application) createMovies(r *http.Request, movies []*data.Movie, results []bulkResult, atomic bool) ([]bulkResult, int, error) {
+	invalid := 0
+
+	genres, err := app.models.Genres.Lookup()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// validate every movie before anything is written
+	for i, movie := range movies {
+		v := validator.New()
+		for key, message := range results[i].Errors {
+			v.AddError(key, message)
+		}
+
+		movie.Genres = genres.Canonicalize(movie.Genres)
+
+		if data.ValidateMovie(v, movie, genres); !v.Valid() {
+			results[i].Status = "invalid"
+			results[i].Errors = v.Errors
+			invalid++
+		}
+	}
+
+	if atomic {
+		// one invalid movie means nothing is created
+		if invalid > 0 {
+			for i := range results {
+				if results[i].Status == "" {
+					results[i].Status = "skipped"
+				}
+			}
+
+			return results, http.StatusUnprocessableEntity, nil
+		}
+
+		err := app.models.Movies.InsertMany(movies, app.contextGetUser(r).ID)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for i := range results {
+			results[i].Status = "created"
+			results[i].ID = movies[i].ID
+		}
+
+		return results, http.StatusCreated, nil
+	}
+
+	// best effort: insert the valid movies one at a time
+	failed := invalid
+	for i := range results {
+		if results[i].Status != "" {
+			continue
+		}
+
+		err := app.models.Movies.Insert(movies[i], app.contextGetUser(r).ID)
+		if err != nil {
+			app.logError(r, err)
+			results[i].Status = "failed"
+			failed++
+			continue
+		}
+
+		results[i].Status = "created"
+		results[i].ID = movies[i].ID
+	}
+
+	// 207 Multi-Status tells the client to check each result
+	if failed > 0 {
+		return results, http.StatusMultiStatus, nil
+	}
+
+	return results, http.StatusCreated, nil
+}
+
+// resolves the movie's genres to their canonical names, so aliases like
+// "sci-fi" are accepted, and then validates the movie
+func (app *application) validateMovie(v *validator.Validator, movie *data.Movie) error {
+	genres, err := app.models.Genres.Lookup()
+	if err != nil {
+		return err
+	}
+
+	movie.Genres = genres.Canonicalize(movie.Genres)
+
+	data.ValidateMovie(v, movie, genres)
+
+	return nil
+}
+
+// gets the movie whose credits or images are being changed, checking If-Match
+// against it as they're part of the movie; writes the error response and
+// returns false if the request can't go ahead. The change must be made at
+// the returned movie's version, so one made since isn't overwritten
+func (app *application) movieForChange(w http.ResponseWriter, r *http.Request) (*data.Movie, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return movie, app.checkIfMatch(w, r, movieETag(movie))
+}
+
 // handler for "GET /v1/movies/:id"
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -68,7 +273,17 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+
+	// only these fields are returned if given, e.g. fields=id,title,credits
+	fields := app.readCSV(r.URL.Query(), "fields", []string{})
+
+	if data.ValidateFields(v, fields, movieShowFieldSafelist); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.GetFields(id, fields)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -79,7 +294,38 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// the client's copy is still current
+	etag := movieETag(movie)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	// cast, crew and images are embedded in the movie; changing them bumps its version
+	if len(fields) == 0 || validator.In("credits", fields...) {
+		movie.Credits, err = app.models.Credits.GetAllForMovie(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if len(fields) == 0 || validator.In("images", fields...) {
+		movie.Images, err = app.movieImages(movie.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	env := envelope{"movie": movie}
+	if len(fields) > 0 {
+		env["movie"] = movie.Select(fields)
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
 	if err != nil {
 		// something went wrong, throw error
 		app.serverErrorResponse(w, r, err)
@@ -107,45 +353,70 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// create generic struct to hold input data
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	// read request body
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// the client must have based its changes on the current version
+	if !app.checkIfMatch(w, r, movieETag(movie)) {
 		return
 	}
 
-	// update our movie values where input values are included in request
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	// patch documents are applied to the movie, anything else is read as
+	// a plain JSON body of the fields to change
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case jsonpatch.MergePatchType, jsonpatch.JSONPatchType:
+		err = app.patchMovie(w, r, mediaType, movie)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+	default:
+		// create generic struct to hold input data
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		// read request body
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// update our movie values where input values are included in request
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
 	}
 
 	// check values before pushing to database
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	err = app.validateMovie(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// update record
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -156,13 +427,57 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
 	// write JSON response
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// the fields of a movie that a patch document is applied to; id and
+// version are included so they can be tested, but can't be changed
+type moviePatchDocument struct {
+	ID      int64        `json:"id"`
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	Version int32        `json:"version"`
+}
+
+// applies a JSON Merge Patch or JSON Patch request body to movie
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, mediaType string, movie *data.Movie) error {
+	src := moviePatchDocument{
+		ID:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Version: movie.Version,
+	}
+
+	var dst moviePatchDocument
+
+	err := app.readPatch(w, r, mediaType, src, &dst)
+	if err != nil {
+		return err
+	}
+
+	if dst.ID != movie.ID || dst.Version != movie.Version {
+		return errors.New("patch must not change the id or version")
+	}
+
+	movie.Title = dst.Title
+	movie.Year = dst.Year
+	movie.Runtime = dst.Runtime
+	movie.Genres = dst.Genres
+
+	return nil
+}
+
 // handler for "DELETE /v1/movies/:id"
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// grab movie ID from URL
@@ -172,10 +487,33 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// conditional deletes need the current version to compare against, and
+	// only go ahead if it's still the current one when the movie is deleted
+	var version int32
+	if r.Header.Get("If-Match") != "" || app.config.preconditions.required {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movieETag(movie)) {
+			return
+		}
+		version = movie.Version
+	}
+
 	// delete movie from database, else error out
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, version, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.versionConflictResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -191,13 +529,91 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 }
 
+// sort values accepted by the movie listing and export endpoints
+var movieSortSafelist = []string{
+	"id", "title", "year", "runtime", "relevance", "average_rating", "ratings_count",
+	"-id", "-title", "-year", "-runtime", "-average_rating", "-ratings_count",
+}
+
+// fields that can be picked on a single movie, which also has its credits and images
+var movieShowFieldSafelist = append(append([]string{}, data.MovieFieldSafelist...), "credits", "images")
+
+// title, genre, range, people and search filters shared by the movie listing and export endpoints
+type movieFilters struct {
+	Title  string
+	Genres []string
+	Ranges data.MovieRanges
+	People data.MoviePeople
+	Search data.MovieSearch
+}
+
+// reads the movie filters from the query string and validates them
+func (app *application) readMovieFilters(qs url.Values, v *validator.Validator) (movieFilters, error) {
+	var f movieFilters
+
+	// calls to helpers.go functions to set values
+	f.Title = app.readString(qs, "title", "")
+	f.Genres = app.readCSV(qs, "genres", []string{})
+
+	// stored genres use canonical names, so aliases like "sci-fi" and old
+	// spellings have to be resolved to match them, as they are on create
+	if len(f.Genres) > 0 {
+		genres, err := app.models.Genres.Lookup()
+		if err != nil {
+			return f, err
+		}
+		f.Genres = genres.Canonicalize(f.Genres)
+	}
+
+	// optional year and runtime bounds
+	f.Ranges.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	f.Ranges.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	f.Ranges.RuntimeMin = app.readRuntime(qs, "runtime_min", 0, v)
+	f.Ranges.RuntimeMax = app.readRuntime(qs, "runtime_max", 0, v)
+
+	// movies crediting a person in any role, or directed by someone with this name
+	f.People.PersonID = int64(app.readInt(qs, "person_id", 0, v))
+	f.People.Director = app.readString(qs, "director", "")
+
+	// full-text search options for the title
+	f.Search.Language = app.readString(qs, "search_language", "simple")
+	f.Search.Highlight = app.readBool(qs, "highlight", false, v)
+	f.Search.Fuzzy = app.readBool(qs, "fuzzy", false, v)
+
+	data.ValidateMovieRanges(v, f.Ranges)
+	data.ValidateMoviePeople(v, f.People)
+	data.ValidateMovieSearch(v, f.Search)
+
+	return f, nil
+}
+
+// reads the movie filters and sort order for endpoints that return every
+// matching movie without paging, like the export and stream endpoints
+func (app *application) readUnpagedMovieFilters(qs url.Values, v *validator.Validator) (movieFilters, data.Filters, error) {
+	f, err := app.readMovieFilters(qs, v)
+	if err != nil {
+		return f, data.Filters{}, err
+	}
+
+	sort := data.Filters{
+		Sort:         app.readString(qs, "sort", "id"),
+		SortSafelist: movieSortSafelist,
+	}
+
+	data.ValidateSort(v, sort)
+	v.Check(!sort.SortsBy("relevance") || f.Title != "", "sort", "relevance requires a title search")
+
+	return f, sort, nil
+}
+
 // handler for "GET /v1/movies?querystring...
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 
 	// expected values from query string
 	var input struct {
-		Title  string
-		Genres []string
+		movieFilters
+		Facets []string
+		Fields []string
 		data.Filters
 	}
 
@@ -205,28 +621,91 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	qs := r.URL.Query()
 
-	// calls to helpers.go functions to set values
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	var err error
+
+	// title, genre, range, people and search filters
+	input.movieFilters, err = app.readMovieFilters(qs, v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// aggregated counts to return with the listing, e.g. facets=genres,decade
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
+	// only these fields of each movie are returned if given, e.g. fields=id,title
+	input.Fields = app.readCSV(qs, "fields", []string{})
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 
 	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafelist = movieSortSafelist
+
+	// opaque cursor from a previous response's metadata, used instead of page
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+
+	data.ValidateFacets(v, input.Facets)
+	data.ValidateFields(v, input.Fields, data.MovieFieldSafelist)
+
+	// ranking needs something to rank against
+	v.Check(!input.SortsBy("relevance") || input.Title != "", "sort", "relevance requires a title search")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Ranges, input.People, input.Search, input.Fields, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidCursor):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	if len(input.Fields) > 0 {
+		selected := make([]map[string]interface{}, len(movies))
+		for i, movie := range movies {
+			selected[i] = movie.Select(input.Fields)
+		}
+
+		env["movies"] = selected
+	}
+
+	if len(input.Facets) > 0 {
+		// count with the same kind of search the listing ended up using
+		input.Search.Fuzzy = input.Search.Fuzzy || metadata.Fuzzy
+
+		facets, err := app.models.Movies.GetFacets(input.Title, input.Genres, input.Ranges, input.People, input.Search, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env["facets"] = facets
+	}
+
+	// the page changes whenever one of its movies does
+	etag, err := envelopeETag(env)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/storage"
 )
 
 func (app *application) routes() http.Handler {
@@ -19,14 +22,109 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthCheckHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMoviesHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission(data.PermissionMoviesRead, app.listMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission(data.PermissionMoviesWrite, app.createMovieHandler))
+	// POST /v1/movies/bulk and /import share their path segment with the :id routes below
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.staticSegments("id", map[string]http.HandlerFunc{
+		"bulk":   app.bulkCreateMoviesHandler,
+		"import": app.importMoviesHandler,
+	}, nil)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesRead, app.staticSegments("id", map[string]http.HandlerFunc{
+		"export": app.exportMoviesHandler,
+		"stream": app.streamMoviesHandler,
+	}, app.showMovieHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.updateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieHandler))
+
+	// routes for movie revision history
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission(data.PermissionMoviesRead, app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/diff", app.requirePermission(data.PermissionMoviesRead, app.diffMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert", app.requirePermission(data.PermissionMoviesWrite, app.revertMovieHandler))
+
+	// route for recommendations based on a movie
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission(data.PermissionMoviesRead, app.listSimilarMoviesHandler))
+
+	// routes for a movie's cast and crew
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission(data.PermissionMoviesWrite, app.createCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission(data.PermissionMoviesWrite, app.deleteCreditHandler))
+
+	// routes for a movie's poster and still images
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/images", app.requirePermission(data.PermissionMoviesWrite, app.uploadMovieImageHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/images/:image_id", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieImageHandler))
+
+	// images kept on local disk are served by the API itself, unless
+	// they're configured to be served from somewhere else
+	if local, ok := app.storage.(*storage.Local); ok && strings.HasPrefix(app.config.images.baseURL, "/") {
+		prefix := strings.TrimSuffix(app.config.images.baseURL, "/")
+		router.Handler(http.MethodGet, prefix+"/*key", http.StripPrefix(prefix, local))
+	}
+
+	// routes for user reviews and ratings of a movie; a review is the
+	// user's own, so only read access to the catalog is needed
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.createReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews", app.requirePermission(data.PermissionMoviesRead, app.deleteReviewHandler))
+
+	// routes for deleted movies
+	router.HandlerFunc(http.MethodGet, "/v1/trash/movies", app.requirePermission(data.PermissionMoviesWrite, app.listTrashedMoviesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/trash/movies/:id/restore", app.requirePermission(data.PermissionMoviesWrite, app.restoreMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/trash/movies", app.requirePermission(data.PermissionMoviesWrite, app.purgeTrashedMoviesHandler))
+
+	// routes for managing genres
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission(data.PermissionMoviesRead, app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission(data.PermissionMoviesWrite, app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.requirePermission(data.PermissionMoviesRead, app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission(data.PermissionMoviesWrite, app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requirePermission(data.PermissionMoviesWrite, app.deleteGenreHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres/:id/merge", app.requirePermission(data.PermissionMoviesWrite, app.mergeGenreHandler))
+
+	// routes for managing people credited on movies
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission(data.PermissionMoviesRead, app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission(data.PermissionMoviesWrite, app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission(data.PermissionMoviesRead, app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission(data.PermissionMoviesWrite, app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission(data.PermissionMoviesWrite, app.deletePersonHandler))
+
+	// routes for the authenticated user's watchlist
+	router.HandlerFunc(http.MethodGet, "/v1/watchlist", app.requirePermission(data.PermissionMoviesRead, app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/watchlist", app.requirePermission(data.PermissionMoviesRead, app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/watchlist/:movie_id", app.requirePermission(data.PermissionMoviesRead, app.updateWatchlistEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/watchlist/:movie_id", app.requirePermission(data.PermissionMoviesRead, app.removeFromWatchlistHandler))
 
 	// route for /v1/users endpoint
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+
+	// routes for admins managing users
+	router.HandlerFunc(http.MethodGet, "/v1/users", app.requirePermission(data.PermissionUsersAdmin, app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id", app.requirePermission(data.PermissionUsersAdmin, app.showUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id", app.requirePermission(data.PermissionUsersAdmin, app.updateUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/logout", app.requirePermission(data.PermissionUsersAdmin, app.logoutUserHandler))
+
+	// route for logging in
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	return app.recoverPanic(app.rateLimit(app.authenticate(router)))
+}
+
+// httprouter doesn't allow a static path segment next to a wildcard one,
+// so this routes requests for fixed values of the wildcard param to their
+// own handlers, and everything else to fallback (or a 404 if it's nil)
+func (app *application) staticSegments(param string, handlers map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName(param)]; ok {
+			handler(w, r)
+			return
+		}
+
+		if fallback == nil {
+			app.notFoundResponse(w, r)
+			return
+		}
 
-	return app.recoverPanic(app.rateLimit(router))
+		fallback(w, r)
+	}
 }
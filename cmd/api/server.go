@@ -18,7 +18,7 @@ func (app *application) serve() error {
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: 30 * time.Second, // lifted by streamMoviesHandler for long streams
 	}
 
 	// used to receive shutdown errors from Shutdown()
@@ -46,7 +46,19 @@ func (app *application) serve() error {
 
 		// Shutdown() returns nil if everything is fine, or error if there
 		// was a problem; relayed to shutdownError
-		shutdownError <- srv.Shutdown(ctx)
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		// wait for background tasks, like sending emails, to finish
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		app.wg.Wait()
+		shutdownError <- nil
 	}()
 
 	// log the start
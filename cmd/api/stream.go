@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// movies written between flushes of a stream
+const streamFlushEvery = 100
+
+// handler for "GET /v1/movies/stream"; writes every matching movie as one
+// line of JSON, reading them from a server-side cursor as it goes
+func (app *application) streamMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	// same filters and sorting as listMoviesHandler, but without paging
+	filters, sort, err := app.readUnpagedMovieFilters(r.URL.Query(), v)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// a full catalog takes longer than the server's WriteTimeout, so lift it
+	// for this response; a client that goes away still cancels the context
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	enc := json.NewEncoder(w)
+
+	rows := 0
+	err = app.models.Movies.ForEach(r.Context(), filters.Title, filters.Genres, filters.Ranges, filters.People, filters.Search, sort, func(movie *data.Movie) error {
+		err := enc.Encode(movie)
+		if err != nil {
+			return err
+		}
+
+		rows++
+
+		if rows%streamFlushEvery == 0 {
+			return rc.Flush()
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		// nothing has been sent yet, so a proper error response is still possible
+		if rows == 0 {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		// the stream is already under way, so break it off rather than let
+		// it look complete, logging why (unless the client simply went away)
+		if r.Context().Err() == nil {
+			app.logError(r, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	rc.Flush()
+}
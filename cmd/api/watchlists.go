@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"greenlight.johnboucha.com/internal/data"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// handler for "GET /v1/watchlist?list=..."
+func (app *application) listWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		List string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	userID := app.contextGetUser(r).ID
+
+	input.List = app.readString(qs, "list", "want_to_watch")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// in the user's own order by default
+	input.Filters.Sort = app.readString(qs, "sort", "position")
+	input.Filters.SortSafelist = []string{"position", "added_at", "watched_at", "-position", "-added_at", "-watched_at"}
+
+	v.Check(validator.In(input.List, data.WatchlistSafelist...), "list", "must be want_to_watch or watched")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.Watchlists.GetAll(userID, input.List, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"watchlist": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// handler for "POST /v1/watchlist"
+func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID   int64      `json:"movie_id"`
+		List      string     `json:"list"`
+		Position  int32      `json:"position"`
+		WatchedAt *time.Time `json:"watched_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	entry := &data.WatchlistEntry{
+		UserID:    app.contextGetUser(r).ID,
+		MovieID:   input.MovieID,
+		List:      input.List,
+		Position:  input.Position,
+		WatchedAt: input.WatchedAt,
+	}
+
+	if data.ValidateWatchlistEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watchlists.Insert(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("movie_id", "must refer to an existing movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrDuplicateWatchlistEntry):
+			v.AddError("movie_id", "is already on the watchlist, move it instead")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrUnknownUser):
+			// the token is valid, but its user has no account here
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// handler for "PATCH /v1/watchlist/:movie_id"; moves a movie to the other
+// list or to another position in its list
+func (app *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	entry, err := app.models.Watchlists.Get(userID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		List      *string    `json:"list"`
+		Position  *int32     `json:"position"`
+		WatchedAt *time.Time `json:"watched_at"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// a movie moved to another list goes to its end, unless a position is given
+	if input.List != nil && *input.List != entry.List {
+		entry.List = *input.List
+		entry.Position = 0
+		entry.WatchedAt = nil
+	}
+	if input.Position != nil {
+		entry.Position = *input.Position
+	}
+	if input.WatchedAt != nil {
+		entry.WatchedAt = input.WatchedAt
+	}
+
+	v := validator.New()
+
+	if data.ValidateWatchlistEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Watchlists.Update(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownUser):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// handler for "DELETE /v1/watchlist/:movie_id"
+func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	err = app.models.Watchlists.Delete(userID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrUnknownUser):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,222 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+var (
+	ErrDuplicateCredit = errors.New("duplicate credit")
+	ErrUnknownPerson   = errors.New("unknown person")
+)
+
+// roles a person can be credited with, in the order credits are listed
+var CreditRoleSafelist = []string{"director", "writer", "actor"}
+
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// Credit links a person to a movie in a role; Character is only set for actors
+type Credit struct {
+	ID        int64  `json:"id"`
+	MovieID   int64  `json:"-"`
+	PersonID  int64  `json:"person_id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	Character string `json:"character,omitempty"`
+}
+
+// MoviePeople holds optional filters on the people credited on a movie;
+// zero values aren't applied
+type MoviePeople struct {
+	PersonID int64
+	Director string
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoleSafelist...), "role", "must be director, writer or actor")
+
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.Character == "" || credit.Role == "actor", "character", "must only be set for actors")
+}
+
+func ValidateMoviePeople(v *validator.Validator, people MoviePeople) {
+	v.Check(people.PersonID >= 0, "person_id", "must not be negative")
+	v.Check(len(people.Director) <= 500, "director", "must not be more than 500 bytes long")
+}
+
+// lists the credits of a movie, directors first, then writers, then actors
+// in the order they were added
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.id, c.movie_id, c.person_id, p.name, c.role, c.character_name
+		FROM movie_credits AS c
+		JOIN people AS p ON p.id = c.person_id
+		WHERE c.movie_id = $1
+		ORDER BY array_position($2, c.role), c.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, pq.Array(CreditRoleSafelist))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.Name,
+			&credit.Role,
+			&credit.Character,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// adds a credit to a movie at the given version; the movie's version goes
+// up, as its credits are part of what clients see of it
+func (m CreditModel) Insert(credit *Credit, version int32, userID int64) error {
+	query := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character_name)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, (SELECT name FROM people WHERE id = $2)`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, credit.Character}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = touchMovie(ctx, tx, credit.MovieID, version, RevisionCredits, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.Name)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) {
+			switch pqErr.Code.Name() {
+			case "unique_violation":
+				return ErrDuplicateCredit
+			case "foreign_key_violation":
+				return ErrUnknownPerson
+			}
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// removes a credit from a movie at the given version
+func (m CreditModel) Delete(movieID, id int64, version int32, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM movie_credits
+		WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = touchMovie(ctx, tx, movieID, version, RevisionCredits, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return tx.Commit()
+}
+
+// bumps the version of a movie whose related records changed, recording a
+// revision with the given action for the new version so the history has no
+// gaps; a version other than 0 must still be the movie's current one
+func touchMovie(ctx context.Context, tx *sql.Tx, movieID int64, version int32, action string, userID int64) error {
+	query := `
+		UPDATE movies
+		SET version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+		RETURNING id, created_at, title, year, runtime, genres, version`
+
+	var movie Movie
+
+	err := tx.QueryRowContext(ctx, query, movieID, version).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && version != 0:
+			return ErrEditConflict
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return insertRevision(ctx, tx, &movie, action, userID)
+}
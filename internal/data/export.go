@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// calls fn for every movie matching the same filters as GetAll, in sort
+// order and without paging; used for exports, so rows are read from a
+// server-side cursor and handed over in batches instead of being collected
+// first. Cancelling ctx stops the query.
+func (m MovieModel) ForEach(ctx context.Context, title string, genres []string, ranges MovieRanges, people MoviePeople, search MovieSearch, filters Filters, fn func(*Movie) error) error {
+	var args queryArgs
+
+	tq := search.titleQuery(&args, title)
+	where := movieWhere(&args, tq.match, genres, ranges, people)
+
+	// ranks are negated, as in GetAll
+	orderBy := filters.orderBy(map[string]string{"relevance": "-" + tq.rank})
+
+	query := fmt.Sprintf(`
+        SELECT id, created_at, title, year, runtime, genres, version, average_rating, ratings_count
+        FROM movies
+        WHERE %s
+        ORDER BY %s, id ASC`, where, orderBy)
+
+	// a server-side cursor needs a transaction to live in
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DECLARE movies_cursor NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		return err
+	}
+
+	// fetch a batch at a time, so only one batch is ever held in memory
+	for {
+		n, err := fetchMovies(ctx, tx, fn)
+		if err != nil {
+			return err
+		}
+
+		if n < movieFetchSize {
+			break
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rows read from the server-side cursor per round trip
+const movieFetchSize = 500
+
+// fetches the next batch of rows from movies_cursor, calls fn for each of
+// them, and returns how many rows there were
+func fetchMovies(ctx context.Context, tx *sql.Tx, fn func(*Movie) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM movies_cursor", movieFetchSize))
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	n := 0
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingsCount,
+		)
+
+		if err != nil {
+			return n, err
+		}
+
+		n++
+
+		err = fn(&movie)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, rows.Err()
+}
@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// facets that can be requested alongside a movie listing
+var FacetSafelist = []string{"genres", "decade"}
+
+// FacetCount holds the number of matching movies for one facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps each requested facet to its counts
+type Facets map[string][]FacetCount
+
+func ValidateFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.In(facet, FacetSafelist...), "facets", "invalid facet value")
+	}
+
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+// returns the grouping expression, the label of each group and the
+// ordering of the groups for a facet
+func facetExpressions(facet string) (string, string, string) {
+	switch facet {
+	case "genres":
+		return "genre", "genre", "count(*) DESC, genre"
+	case "decade":
+		return "(year / 10) * 10", "((year / 10) * 10)::text || 's'", "(year / 10) * 10"
+	}
+
+	panic("unsafe facet parameter: " + facet)
+}
+
+// counts the movies matching the same title, genre, range and people filters as
+// GetAll, grouped by each of the requested facets
+func (m MovieModel) GetFacets(title string, genres []string, ranges MovieRanges, people MoviePeople, search MovieSearch, facets []string) (Facets, error) {
+	result := Facets{}
+
+	for _, facet := range facets {
+		var args queryArgs
+
+		tq := search.titleQuery(&args, title)
+		where := movieWhere(&args, tq.match, genres, ranges, people)
+
+		group, label, order := facetExpressions(facet)
+
+		// genres are counted per array element
+		from := "movies"
+		if facet == "genres" {
+			from = "movies, unnest(genres) AS genre"
+		}
+
+		query := fmt.Sprintf(`
+			SELECT %s, count(*)
+			FROM %s
+			WHERE %s
+			GROUP BY %s
+			ORDER BY %s`, label, from, where, group, order)
+
+		// context with 3-second timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+		counts, err := m.scanFacetCounts(ctx, query, args)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		result[facet] = counts
+	}
+
+	return result, nil
+}
+
+// runs a facet query and scans its value and count rows
+func (m MovieModel) scanFacetCounts(ctx context.Context, query string, args queryArgs) ([]FacetCount, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	counts := []FacetCount{}
+
+	for rows.Next() {
+		var count FacetCount
+
+		err := rows.Scan(&count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		counts = append(counts, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// movie fields that can be picked with the fields query parameter; each is
+// read from the column of the same name
+var MovieFieldSafelist = []string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "ratings_count"}
+
+// every movie column, selected when no fields are picked
+var movieColumnList = []string{"id", "created_at", "title", "year", "runtime", "genres", "version", "average_rating", "ratings_count"}
+
+func ValidateFields(v *validator.Validator, fields []string, safelist []string) {
+	for _, field := range fields {
+		v.Check(validator.In(field, safelist...), "fields", "invalid field value: "+field)
+	}
+
+	v.Check(validator.Unique(fields), "fields", "must not contain duplicate values")
+}
+
+// returns the movie columns to select for the picked fields, or every column
+// if none were picked. id, version and the rating are always selected, as
+// cursors and ETags need them, as are the sort columns
+func movieColumns(fields []string, sortColumns []string) []string {
+	if len(fields) == 0 {
+		return movieColumnList
+	}
+
+	columns := []string{"id", "version", "average_rating", "ratings_count"}
+
+	for _, column := range append(append([]string{}, fields...), sortColumns...) {
+		if validator.In(column, movieColumnList...) && !validator.In(column, columns...) {
+			columns = append(columns, column)
+		}
+	}
+
+	return columns
+}
+
+// returns where Scan should store the value of a movie column
+func (movie *Movie) scanDest(column string) interface{} {
+	switch column {
+	case "id":
+		return &movie.ID
+	case "created_at":
+		return &movie.CreatedAt
+	case "title":
+		return &movie.Title
+	case "year":
+		return &movie.Year
+	case "runtime":
+		return &movie.Runtime
+	case "genres":
+		return pq.Array(&movie.Genres)
+	case "version":
+		return &movie.Version
+	case "average_rating":
+		return &movie.AverageRating
+	case "ratings_count":
+		return &movie.RatingsCount
+	}
+
+	panic("unknown movie column: " + column)
+}
+
+// returns only the picked fields of a movie, for responses that were asked
+// for some of them; search highlights and scores are kept when present, as
+// they were asked for separately
+func (movie *Movie) Select(fields []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(fields))
+
+	for _, field := range fields {
+		switch field {
+		case "id":
+			selected[field] = movie.ID
+		case "title":
+			selected[field] = movie.Title
+		case "year":
+			selected[field] = movie.Year
+		case "runtime":
+			selected[field] = movie.Runtime
+		case "genres":
+			selected[field] = movie.Genres
+		case "version":
+			selected[field] = movie.Version
+		case "average_rating":
+			selected[field] = movie.AverageRating
+		case "ratings_count":
+			selected[field] = movie.RatingsCount
+		case "credits":
+			selected[field] = movie.Credits
+		case "images":
+			selected[field] = movie.Images
+		}
+	}
+
+	if movie.Highlight != "" {
+		selected["highlight"] = movie.Highlight
+	}
+	if movie.Similarity != 0 {
+		selected["similarity"] = movie.Similarity
+	}
+
+	return selected
+}
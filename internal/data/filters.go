@@ -1,17 +1,25 @@
 package data
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"strings"
 
 	"greenlight.johnboucha.com/internal/validator"
 )
 
+// used when a cursor cannot be decoded or doesn't fit the query it's used with
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
-	Sort         string
+	Sort         string // one or more comma-separated sort keys, e.g. "-year,title"
 	SortSafelist []string
+	Cursor       string
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
@@ -21,29 +29,131 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be less than 100")
 
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	ValidateSort(v, f)
+
+	// a cursor replaces the page number and is only valid for the sort it was issued for
+	if f.Cursor != "" {
+		v.Check(f.Page == 1, "page", "must not be used together with cursor")
+
+		c, err := decodeCursor(f.Cursor)
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+			return
+		}
+
+		v.Check(c.Sort == f.Sort && len(c.Values) == len(f.sortKeys()), "cursor", "does not match the sort value")
+	}
+}
+
+// checks every key of the sort against the safelist; a column can only be
+// sorted on once, and at most 3 keys can be combined
+func ValidateSort(v *validator.Validator, f Filters) {
+	keys := f.sortKeys()
+	columns := make([]string, len(keys))
+
+	for i, key := range keys {
+		v.Check(validator.In(key, f.SortSafelist...), "sort", "invalid sort value")
+		columns[i] = strings.TrimPrefix(key, "-")
+	}
+
+	v.Check(len(keys) <= 3, "sort", "must not contain more than 3 sort keys")
+	v.Check(validator.Unique(columns), "sort", "must not sort on the same field more than once")
+}
+
+// reports whether one of the sort keys is column, in either direction
+func (f Filters) SortsBy(column string) bool {
+	for _, key := range f.sortKeys() {
+		if strings.TrimPrefix(key, "-") == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splits the sort parameter into its keys
+func (f Filters) sortKeys() []string {
+	return strings.Split(f.Sort, ",")
+}
+
+// checks if sort parameter is safe, and returns the column of each sort key
+func (f Filters) sortColumns() []string {
+	keys := f.sortKeys()
+	columns := make([]string, len(keys))
+
+	for i, key := range keys {
+		if !validator.In(key, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + key)
+		}
+
+		columns[i] = strings.TrimPrefix(key, "-")
+	}
+
+	return columns
 }
 
-// checks if sort parameter is safe
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+// returns the SQL expression of each sort key: its column, unless exprs
+// maps the column to an expression, as for sorting on relevance
+func (f Filters) sortExprs(exprs map[string]string) []string {
+	columns := f.sortColumns()
+
+	for i, column := range columns {
+		if expr, ok := exprs[column]; ok {
+			columns[i] = expr
 		}
 	}
 
-	panic("unsafe sort parameter: " + f.Sort)
+	return columns
 }
 
-// returns sort direction ("ASC" or "DEC") for SQL query
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+// returns sort direction ("ASC" or "DESC") of a sort key for SQL query
+func sortDirection(key string) string {
+	if strings.HasPrefix(key, "-") {
 		return "DESC"
 	}
 
 	return "ASC"
 }
 
+// returns the ORDER BY list for the sort keys, e.g. "year DESC, title ASC";
+// queries append their own tiebreaker. exprs is passed on to sortExprs
+func (f Filters) orderBy(exprs map[string]string) string {
+	keys := f.sortKeys()
+	terms := f.sortExprs(exprs)
+
+	for i := range terms {
+		terms[i] += " " + sortDirection(keys[i])
+	}
+
+	return strings.Join(terms, ", ")
+}
+
+// returns the condition selecting the rows that come after a cursor, given
+// the SQL expression and cursor value placeholder of each sort key, and the
+// placeholder of the cursor's id tiebreaker, which is always ascending.
+// For "-year,title" that is:
+// year < $1 OR (year = $1 AND title > $2) OR (year = $1 AND title = $2 AND id > $3)
+func (f Filters) keysetCondition(exprs, values []string, id string) string {
+	keys := f.sortKeys()
+
+	var alternatives, equal []string
+
+	for i, key := range keys {
+		// rows after the cursor in this key's direction, when all keys before it are equal
+		operator := ">"
+		if strings.HasPrefix(key, "-") {
+			operator = "<"
+		}
+
+		alternatives = append(alternatives, "("+strings.Join(append(equal, exprs[i]+" "+operator+" "+values[i]), " AND ")+")")
+		equal = append(equal, exprs[i]+" = "+values[i])
+	}
+
+	alternatives = append(alternatives, "("+strings.Join(append(equal, "id > "+id), " AND ")+")")
+
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
 // return LIMIT from the page_size in query string
 // for example: /v1/movies?page_size=5&page=3
 func (f Filters) limit() int {
@@ -56,13 +166,61 @@ func (f Filters) offset() int {
 	return (f.Page - 1) * f.PageSize
 }
 
+// cursor holds the sort values and id of the last row on a page, so the
+// next page can start right after it instead of counting an OFFSET
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"` // one per sort key
+	ID     int64    `json:"i"`
+	Fuzzy  bool     `json:"f,omitempty"` // the page came from a typo-tolerant search
+}
+
+// encodes a cursor into the opaque string handed out to clients
+func encodeCursor(c cursor) string {
+	js, err := json.Marshal(c)
+	if err != nil {
+		// a struct of strings and ints can always be marshaled
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodes a cursor string from the query string
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 {
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// queryArgs collects the arguments of a query that is built up from optional
+// conditions, handing out the placeholder for each value as it's added
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
 // Metadata struct holds information for pagination
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	Fuzzy        bool   `json:"fuzzy,omitempty"` // results came from typo-tolerant matching
 }
 
 // calculates values for Metadata, for pagination
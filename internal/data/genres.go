@@ -0,0 +1,476 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// runs of anything other than letters and digits become a single dash in a slug
+var slugSeparatorRX = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// Genre is a canonical genre; movies store its name, and its slug and
+// aliases let other spellings resolve to it
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+// turns a genre name or alias into its slug, e.g. "Sci Fi" becomes "sci-fi";
+// also used as the key when resolving genres, so matching ignores case and punctuation
+func Slugify(s string) string {
+	return strings.Trim(slugSeparatorRX.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// GenreLookup maps the slugs of every genre's name, slug and aliases to the genre
+type GenreLookup map[string]*Genre
+
+// returns the keys a genre can be looked up by
+func (g *Genre) keys() []string {
+	keys := []string{Slugify(g.Name), g.Slug}
+	for _, alias := range g.Aliases {
+		keys = append(keys, Slugify(alias))
+	}
+	return keys
+}
+
+// replaces names that resolve to a genre with its canonical name;
+// unknown names are kept as they are, for ValidateMovie to report
+func (l GenreLookup) Canonicalize(names []string) []string {
+	if names == nil {
+		return nil
+	}
+
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = name
+		if genre, ok := l[Slugify(name)]; ok {
+			canonical[i] = genre.Name
+		}
+	}
+
+	return canonical
+}
+
+// reports whether name is the canonical name of a genre
+func (l GenreLookup) IsCanonical(name string) bool {
+	genre, ok := l[Slugify(name)]
+	return ok && genre.Name == name
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre, lookup GenreLookup) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(genre.Slug != "", "slug", "must be provided")
+	v.Check(genre.Slug == Slugify(genre.Slug), "slug", "must only contain lowercase letters, digits and dashes")
+
+	v.Check(genre.Aliases != nil, "aliases", "must be provided")
+	for _, alias := range genre.Aliases {
+		v.Check(Slugify(alias) != "", "aliases", "must not contain empty values")
+		v.Check(len(alias) <= 100, "aliases", "must not contain values more than 100 bytes long")
+	}
+
+	// every spelling must resolve to exactly one genre
+	keys := genre.keys()
+	v.Check(validator.Unique(keys[1:]) && !validator.In(keys[0], keys[2:]...), "aliases", "must not repeat the name, slug or each other")
+
+	for i, key := range keys {
+		if other, ok := lookup[key]; ok && other.ID != genre.ID {
+			field := "aliases"
+			switch i {
+			case 0:
+				field = "name"
+			case 1:
+				field = "slug"
+			}
+
+			v.AddError(field, fmt.Sprintf("%q already belongs to genre %q", key, other.Name))
+		}
+	}
+}
+
+// loads every genre, keyed for resolving movie genres
+func (m GenreModel) Lookup() (GenreLookup, error) {
+	query := `
+		SELECT id, created_at, name, slug, aliases, version
+		FROM genres`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	lookup := GenreLookup{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.ID, &genre.CreatedAt, &genre.Name, &genre.Slug, pq.Array(&genre.Aliases), &genre.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range genre.keys() {
+			lookup[key] = &genre
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lookup, nil
+}
+
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `
+		INSERT INTO genres (name, slug, aliases)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{genre.Name, genre.Slug, pq.Array(genre.Aliases)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		return genreError(err)
+	}
+
+	return nil
+}
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, slug, aliases, version
+		FROM genres
+		WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Name,
+		&genre.Slug,
+		pq.Array(&genre.Aliases),
+		&genre.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+func (m GenreModel) GetAll(filters Filters) ([]*Genre, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, slug, aliases, version
+		FROM genres
+		ORDER BY %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&totalRecords,
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Name,
+			&genre.Slug,
+			pq.Array(&genre.Aliases),
+			&genre.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return genres, metadata, nil
+}
+
+// updates a genre; when its name changes, movies using the old name are
+// moved over to the new one in the same transaction
+func (m GenreModel) Update(genre *Genre, oldName string, userID int64) error {
+	query := `
+		UPDATE genres
+		SET name = $1, slug = $2, aliases = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{genre.Name, genre.Slug, pq.Array(genre.Aliases), genre.ID, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return genreError(err)
+		}
+	}
+
+	if genre.Name != oldName {
+		err = replaceMovieGenre(ctx, tx, oldName, genre.Name, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deletes a genre, as long as no movie (including those in the trash) uses it
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM genres AS g
+		WHERE g.id = $1 AND NOT EXISTS (
+			SELECT 1 FROM movies AS m WHERE m.genres @> ARRAY[g.name::text]
+		)
+		RETURNING g.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deleted int64
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&deleted)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// nothing deleted: either there's no such genre, or it's in use
+		_, err = m.Get(id)
+		if err != nil {
+			return err
+		}
+
+		return ErrGenreInUse
+	}
+
+	return nil
+}
+
+// merges a duplicate genre into another: movies using the source are moved
+// to the target, the source's name, slug and aliases become aliases of the
+// target, and the source is deleted. Returns the updated target.
+func (m GenreModel) Merge(sourceID, targetID, userID int64) (*Genre, error) {
+	source, err := m.Get(sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := m.Get(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the source's spellings now resolve to the target
+	aliases := append([]string{}, target.Aliases...)
+	seen := target.keys()
+
+	for _, alias := range append([]string{source.Name, source.Slug}, source.Aliases...) {
+		if !validator.In(Slugify(alias), seen...) {
+			aliases = append(aliases, alias)
+			seen = append(seen, Slugify(alias))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the source goes first, so its name and slug are free to become aliases
+	result, err := tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1 AND version = $2`, source.ID, source.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrEditConflict
+	}
+
+	query := `
+		UPDATE genres
+		SET aliases = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING aliases, version`
+
+	err = tx.QueryRowContext(ctx, query, pq.Array(aliases), target.ID, target.Version).Scan(pq.Array(&target.Aliases), &target.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = replaceMovieGenre(ctx, tx, source.Name, target.Name, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
+
+// replaces a genre name with another in every movie that has it, without
+// duplicating the new name, and records each change as a movie revision
+func replaceMovieGenre(ctx context.Context, tx *sql.Tx, from, to string, userID int64) error {
+	query := `
+		UPDATE movies
+		SET genres = CASE
+				WHEN genres @> ARRAY[$2::text] THEN array_remove(genres, $1::text)
+				ELSE array_replace(genres, $1::text, $2::text)
+			END,
+			version = version + 1
+		WHERE genres @> ARRAY[$1::text]
+		RETURNING id, created_at, title, year, runtime, genres, version`
+
+	rows, err := tx.QueryContext(ctx, query, from, to)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var movies []*Movie
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// the rows must be closed before the connection can run another query
+	rows.Close()
+
+	for _, movie := range movies {
+		err = insertRevision(ctx, tx, movie, RevisionUpdate, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maps unique constraint violations on genres to ErrDuplicateGenre
+func genreError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation" {
+		return ErrDuplicateGenre
+	}
+
+	return err
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// kinds of artwork that can be attached to a movie
+var ImageKindSafelist = []string{"poster", "still"}
+
+// image formats accepted for uploads, as detected from their contents
+var ImageContentTypeSafelist = []string{"image/jpeg", "image/png", "image/webp"}
+
+type ImageModel struct {
+	DB *sql.DB
+}
+
+// Image is an uploaded file attached to a movie; Key is where the file is
+// kept in storage, and URL where clients can get it from
+type Image struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"-"`
+	MovieID     int64     `json:"-"`
+	Kind        string    `json:"kind"`
+	Key         string    `json:"-"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+}
+
+func ValidateImage(v *validator.Validator, image *Image) {
+	v.Check(image.Kind != "", "kind", "must be provided")
+	v.Check(validator.In(image.Kind, ImageKindSafelist...), "kind", "must be poster or still")
+
+	v.Check(validator.In(image.ContentType, ImageContentTypeSafelist...), "image", "must be a JPEG, PNG or WebP image")
+	v.Check(image.Size > 0, "image", "must not be empty")
+}
+
+// lists the images of a movie, posters first
+func (m ImageModel) GetAllForMovie(movieID int64) ([]*Image, error) {
+	query := `
+		SELECT id, created_at, movie_id, kind, key, content_type, size
+		FROM images
+		WHERE movie_id = $1
+		ORDER BY array_position($2, kind), id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, pq.Array(ImageKindSafelist))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	images := []*Image{}
+
+	for rows.Next() {
+		var image Image
+
+		err := rows.Scan(
+			&image.ID,
+			&image.CreatedAt,
+			&image.MovieID,
+			&image.Kind,
+			&image.Key,
+			&image.ContentType,
+			&image.Size,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, &image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// records an image that has been stored for a movie at the given version;
+// the movie's version goes up, as its images are part of what clients see of it
+func (m ImageModel) Insert(image *Image, version int32, userID int64) error {
+	query := `
+		INSERT INTO images (movie_id, kind, key, content_type, size)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+
+	args := []interface{}{image.MovieID, image.Kind, image.Key, image.ContentType, image.Size}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = touchMovie(ctx, tx, image.MovieID, version, RevisionImages, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&image.ID, &image.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// removes an image from a movie at the given version, and returns it so its
+// file can be deleted
+func (m ImageModel) Delete(movieID, id int64, version int32, userID int64) (*Image, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM images
+		WHERE id = $1 AND movie_id = $2
+		RETURNING id, created_at, movie_id, kind, key, content_type, size`
+
+	var image Image
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = touchMovie(ctx, tx, movieID, version, RevisionImages, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, query, id, movieID).Scan(
+		&image.ID,
+		&image.CreatedAt,
+		&image.MovieID,
+		&image.Kind,
+		&image.Key,
+		&image.ContentType,
+		&image.Size,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &image, nil
+}
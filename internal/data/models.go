@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -10,16 +11,42 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")    // used when race conditions occur
 )
 
+// querier is satisfied by both *sql.DB and *sql.Tx, so the same query
+// code can run on its own or as part of a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // Models struct wraps our models
 type Models struct {
-	Movies MovieModel
-	Users  UserModel
+	Credits     CreditModel
+	Genres      GenreModel
+	Images      ImageModel
+	Movies      MovieModel
+	People      PersonModel
+	Permissions PermissionModel
+	Reviews     ReviewModel
+	Revisions   RevisionModel
+	Tokens      TokenModel
+	Users       UserModel
+	Watchlists  WatchlistModel
 }
 
-// returns Models struct containing MovieModel and UserModel
+// returns Models struct containing all of our models
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
-		Users:  UserModel{DB: db},
+		Credits:     CreditModel{DB: db},
+		Genres:      GenreModel{DB: db},
+		Images:      ImageModel{DB: db},
+		Movies:      MovieModel{DB: db},
+		People:      PersonModel{DB: db},
+		Permissions: PermissionModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Revisions:   RevisionModel{DB: db},
+		Tokens:      TokenModel{DB: db},
+		Users:       UserModel{DB: db},
+		Watchlists:  WatchlistModel{DB: db},
 	}
 }
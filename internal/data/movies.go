@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/lib/pq"
@@ -16,16 +18,76 @@ type MovieModel struct {
 }
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"` // exclude from JSON output
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"` // Runtime type found in internal/data/runtime.go
-	Genres    []string  `json:"genres,omitempty"`
-	Version   int32     `json:"version"`
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"-"` // exclude from JSON output
+	Title         string     `json:"title"`
+	Year          int32      `json:"year,omitempty"`
+	Runtime       Runtime    `json:"runtime,omitempty"` // Runtime type found in internal/data/runtime.go
+	Genres        []string   `json:"genres,omitempty"`
+	Credits       []*Credit  `json:"credits,omitempty"` // only loaded for a single movie
+	Images        []*Image   `json:"images,omitempty"`  // only loaded for a single movie
+	Version       int32      `json:"version"`
+	AverageRating float32    `json:"average_rating,omitempty"` // mean review score, kept up to date by ReviewModel
+	RatingsCount  int32      `json:"ratings_count,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"` // set while the movie is in the trash
+	Highlight     string     `json:"highlight,omitempty"`  // ts_headline snippet when searching with highlight=true
+	Similarity    float32    `json:"similarity,omitempty"` // trigram similarity to a fuzzy title search
+	rank          float32    // relevance of a title search, used for relevance cursors
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
+// MovieRanges holds optional bounds on numeric movie fields;
+// a zero value means that bound isn't applied
+type MovieRanges struct {
+	YearMin    int32
+	YearMax    int32
+	RuntimeMin Runtime
+	RuntimeMax Runtime
+}
+
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	// context with a 3-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// the movie and its first revision are written together
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertMovie(ctx, tx, movie, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// inserts all movies in a single transaction, so either all or none are created
+func (m MovieModel) InsertMany(movies []*Movie, userID int64) error {
+	// longer timeout, as this covers one insert per movie
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, movie := range movies {
+		err = insertMovie(ctx, tx, movie, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// inserts a movie along with its "create" revision; q should be a transaction
+func insertMovie(ctx context.Context, q querier, movie *Movie, userID int64) error {
 
 	query := `
 		INSERT INTO movies (title, year, runtime, genres)
@@ -34,40 +96,46 @@ func (m MovieModel) Insert(movie *Movie) error {
 
 	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	// context with a 3-second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return insertRevision(ctx, q, movie, RevisionCreate, userID)
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	return m.GetFields(id, nil)
+}
+
+// gets a movie, only reading the columns of the picked fields (see
+// MovieFieldSafelist); no fields means all of them
+func (m MovieModel) GetFields(id int64, fields []string) (*Movie, error) {
 
 	// Movie ID should not be less than 1
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-		SELECT id, created_at, title, year, runtime, genres, version
+	columns := movieColumns(fields, nil)
+
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM movies
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`, strings.Join(columns, ", "))
 
 	var movie Movie
 
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		dest[i] = movie.scanDest(column)
+	}
+
 	// context created to kill query after 3 seconds
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(dest...)
 
 	if err != nil {
 		switch {
@@ -81,23 +149,74 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// lists the movies matching the filters; only the columns of the picked
+// fields are read, or all of them if there are none
+func (m MovieModel) GetAll(title string, genres []string, ranges MovieRanges, people MoviePeople, search MovieSearch, fields []string, filters Filters) ([]*Movie, Metadata, error) {
+	var args queryArgs
+
+	// a cursor keeps paging in the search mode of the page it came from,
+	// which matters once the exact search has fallen back to a fuzzy one
+	var c cursor
+	if filters.Cursor != "" {
+		var err error
+
+		c, err = decodeCursor(filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if c.Fuzzy {
+			search.Fuzzy = true
+			search.Highlight = false
+		}
+	}
+
+	// title search expressions, and the conditions for the other filters
+	tq := search.titleQuery(&args, title)
+	where := movieWhere(&args, tq.match, genres, ranges, people)
+	whereArgs := len(args)
+
+	// ranks are negated, so the ascending "relevance" sort lists the best matches first
+	exprs := map[string]string{"relevance": "-" + tq.rank}
+
+	// when paging with a cursor, only select rows after the last row of the previous page
+	if filters.Cursor != "" {
+		columns := filters.sortColumns()
+		if len(c.Values) != len(columns) {
+			return nil, Metadata{}, ErrInvalidCursor
+		}
+
+		values := make([]string, len(columns))
+		for i, column := range columns {
+			value, err := movieCursorValue(column, c.Values[i])
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+
+			values[i] = args.add(value)
+		}
+
+		where += " AND " + filters.keysetCondition(filters.sortExprs(exprs), values, args.add(c.ID))
+	}
+
+	// one extra row is fetched to find out whether there is a next page for the cursor
+	limit := args.add(filters.limit() + 1)
+	offset := args.add(filters.offset())
+
+	columns := movieColumns(fields, filters.sortColumns())
+
 	// get all SQL query
 	query := fmt.Sprintf(`
-        SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+        SELECT count(*) OVER(), %s, %s, %s
         FROM movies
-        WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-        AND (genres @> $2 OR $2 = '{}')     
-        ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+        WHERE %s
+        ORDER BY %s, id ASC
+		LIMIT %s OFFSET %s`, strings.Join(columns, ", "), tq.rank, tq.headline, where, filters.orderBy(exprs), limit, offset)
 
 	// context with 3-second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// create arguments to pass into query
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
-
 	// execute the query
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -112,22 +231,24 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	for rows.Next() {
 		var movie Movie
 
+		dest := []interface{}{&totalRecords}
+		for _, column := range columns {
+			dest = append(dest, movie.scanDest(column))
+		}
+		dest = append(dest, &movie.rank, &movie.Highlight)
+
 		// scan row into movie struct
-		err := rows.Scan(
-			&totalRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		err := rows.Scan(dest...)
 
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
+		// the rank of a fuzzy search is its similarity score
+		if search.Fuzzy && title != "" {
+			movie.Similarity = movie.rank
+		}
+
 		movies = append(movies, &movie)
 	}
 
@@ -135,16 +256,148 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		return nil, Metadata{}, err
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// nothing matched the exact search, so retry with typo-tolerant
+	// matching before giving up. Past the first page, an empty page may just
+	// mean the exact matches ran out, so only fall back if there were none
+	if len(movies) == 0 && title != "" && !search.Fuzzy && filters.Cursor == "" {
+		rows.Close()
+
+		noMatches := true
+		if filters.Page > 1 {
+			query := "SELECT NOT EXISTS (SELECT 1 FROM movies WHERE " + where + ")"
+
+			err = m.DB.QueryRowContext(ctx, query, args[:whereArgs]...).Scan(&noMatches)
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+		}
+
+		if noMatches {
+			search.Fuzzy = true
+			search.Highlight = false
+
+			return m.GetAll(title, genres, ranges, people, search, fields, filters)
+		}
+	}
+
+	// trim the extra row, and point the cursor at the last movie on this page
+	nextCursor := ""
+	if len(movies) > filters.limit() {
+		movies = movies[:filters.limit()]
+		last := movies[len(movies)-1]
+
+		next := cursor{Sort: filters.Sort, ID: last.ID, Fuzzy: search.Fuzzy && title != ""}
+		for _, column := range filters.sortColumns() {
+			next.Values = append(next.Values, last.cursorValue(column))
+		}
+
+		nextCursor = encodeCursor(next)
+	}
+
+	// with a cursor, the window count only covers the remaining rows,
+	// so page numbers and totals would be misleading
+	var metadata Metadata
+	if filters.Cursor != "" {
+		metadata = Metadata{PageSize: filters.PageSize}
+	} else {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	}
+	metadata.NextCursor = nextCursor
+	metadata.Fuzzy = search.Fuzzy && title != "" && len(movies) > 0
 
 	return movies, metadata, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// returns the WHERE conditions selecting movies that match the title search
+// and the genre, range and people filters of a listing, adding their values to args
+func movieWhere(args *queryArgs, match string, genres []string, ranges MovieRanges, people MoviePeople) string {
+	// movies in the trash are never listed
+	conditions := []string{"deleted_at IS NULL", match}
+
+	if len(genres) > 0 {
+		conditions = append(conditions, "genres @> "+args.add(pq.Array(genres)))
+	}
+	if ranges.YearMin != 0 {
+		conditions = append(conditions, "year >= "+args.add(ranges.YearMin))
+	}
+	if ranges.YearMax != 0 {
+		conditions = append(conditions, "year <= "+args.add(ranges.YearMax))
+	}
+	if ranges.RuntimeMin != 0 {
+		conditions = append(conditions, "runtime >= "+args.add(ranges.RuntimeMin))
+	}
+	if ranges.RuntimeMax != 0 {
+		conditions = append(conditions, "runtime <= "+args.add(ranges.RuntimeMax))
+	}
+	if people.PersonID != 0 {
+		conditions = append(conditions, "id IN (SELECT movie_id FROM movie_credits WHERE person_id = "+args.add(people.PersonID)+")")
+	}
+	if people.Director != "" {
+		conditions = append(conditions, `id IN (
+			SELECT c.movie_id FROM movie_credits AS c JOIN people AS p ON p.id = c.person_id
+			WHERE c.role = 'director' AND lower(p.name) = lower(`+args.add(people.Director)+`))`)
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+// returns the value of a sort column as stored in a cursor
+func (movie *Movie) cursorValue(column string) string {
+	switch column {
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "ratings_count":
+		return strconv.FormatInt(int64(movie.RatingsCount), 10)
+	case "average_rating":
+		return strconv.FormatFloat(float64(movie.AverageRating), 'g', -1, 32)
+	case "relevance":
+		return strconv.FormatFloat(float64(-movie.rank), 'g', -1, 32)
+	default:
+		return strconv.FormatInt(movie.ID, 10)
+	}
+}
+
+// converts a cursor value back into the type of its sort column
+func movieCursorValue(column, value string) (interface{}, error) {
+	switch column {
+	case "title":
+		return value, nil
+	case "relevance", "average_rating":
+		f, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return f, nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return i, nil
+}
+
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	return m.update(movie, RevisionUpdate, userID)
+}
+
+// updates a movie back to the values of an earlier revision, with the
+// same edit conflict checks as Update
+func (m MovieModel) Revert(movie *Movie, userID int64) error {
+	return m.update(movie, RevisionRevert, userID)
+}
+
+// updates a movie and records the change as a revision with the given action
+func (m MovieModel) update(movie *Movie, action string, userID int64) error {
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version`
 
 	args := []interface{}{
@@ -160,7 +413,13 @@ func (m MovieModel) Update(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -170,45 +429,96 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
-	return nil
+	err = insertRevision(ctx, tx, movie, action, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m MovieModel) Delete(id int64) error {
+// moves a movie to the trash; a version other than 0 must still be the
+// movie's current one
+func (m MovieModel) Delete(id int64, version int32, userID int64) error {
 
 	// movie ID cannot be less than 1
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
+	// movies are moved to the trash, and only removed for good by Purge
 	query := `
-		DELETE FROM movies
-		WHERE id = $1`
+		UPDATE movies
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)
+		RETURNING id, created_at, title, year, runtime, genres, version`
+
+	var movie Movie
 
 	// context with a 3-second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Execute the query by ID
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	// Execute the query by ID; no row means there was nothing to delete
+	err = tx.QueryRowContext(ctx, query, id, version).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows) && version != 0:
+			return ErrEditConflict
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
 
-	// rowsAffeccted should be 1, else return error
-	rowsAffected, err := result.RowsAffected()
+	err = insertRevision(ctx, tx, &movie, RevisionDelete, userID)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	// deleted movies don't stay on anyone's watchlist, even if they're restored
+	err = dropMovieFromWatchlists(ctx, tx, movie.ID)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return tx.Commit()
+}
+
+func ValidateMovieRanges(v *validator.Validator, ranges MovieRanges) {
+	v.Check(ranges.YearMin >= 0, "year_min", "must not be negative")
+	v.Check(ranges.YearMax >= 0, "year_max", "must not be negative")
+	v.Check(ranges.RuntimeMin >= 0, "runtime_min", "must not be negative")
+	v.Check(ranges.RuntimeMax >= 0, "runtime_max", "must not be negative")
 
+	// only compare bounds when both are set
+	if ranges.YearMin != 0 && ranges.YearMax != 0 {
+		v.Check(ranges.YearMin <= ranges.YearMax, "year_min", "must not be greater than year_max")
+	}
+	if ranges.RuntimeMin != 0 && ranges.RuntimeMax != 0 {
+		v.Check(ranges.RuntimeMin <= ranges.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// genres must be canonical genre names; call genres.Canonicalize on the
+// movie's genres first so other known spellings are accepted
+func ValidateMovie(v *validator.Validator, movie *Movie, genres GenreLookup) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -223,4 +533,8 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	for _, genre := range movie.Genres {
+		v.Check(genres.IsCanonical(genre), "genres", fmt.Sprintf("must only contain known genres (%q is unknown)", genre))
+	}
 }
@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+var (
+	ErrPersonHasCredits = errors.New("person has credits")
+)
+
+type PersonModel struct {
+	DB *sql.DB
+}
+
+// Person is someone who can be credited on movies, as cast or crew
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	// the birth year is optional
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (name, birth_year)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.Name, person.BirthYear).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, birth_year, version
+		FROM people
+		WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.BirthYear,
+		&person.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// lists people, optionally searching by name
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, birth_year, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.BirthYear,
+			&person.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// updates a person; a new name shows up in the credits of their movies,
+// so those movies get a new version too, in the same transaction
+func (m PersonModel) Update(person *Person, userID int64) error {
+	// the FROM subquery still sees the name from before the update
+	query := `
+		UPDATE people
+		SET name = $1, birth_year = $2, version = version + 1
+		FROM (SELECT name FROM people WHERE id = $3) AS old
+		WHERE people.id = $3 AND people.version = $4
+		RETURNING people.version, old.name`
+
+	args := []interface{}{person.Name, person.BirthYear, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldName string
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&person.Version, &oldName)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if oldName != person.Name {
+		err = touchCreditedMovies(ctx, tx, person.ID, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// bumps the version of every movie, outside the trash, that credits the person
+func touchCreditedMovies(ctx context.Context, tx *sql.Tx, personID, userID int64) error {
+	query := `
+		SELECT DISTINCT c.movie_id
+		FROM movie_credits AS c
+		JOIN movies AS m ON m.id = c.movie_id
+		WHERE c.person_id = $1 AND m.deleted_at IS NULL
+		ORDER BY c.movie_id`
+
+	rows, err := tx.QueryContext(ctx, query, personID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	var movieIDs []int64
+
+	for rows.Next() {
+		var movieID int64
+
+		err := rows.Scan(&movieID)
+		if err != nil {
+			return err
+		}
+
+		movieIDs = append(movieIDs, movieID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// the rows must be closed before the connection can run another query
+	rows.Close()
+
+	for _, movieID := range movieIDs {
+		err = touchMovie(ctx, tx, movieID, 0, RevisionCredits, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deletes a person; the movie_credits foreign key stops this while any
+// movie, including those in the trash, still credits them
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM people
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation" {
+			return ErrPersonHasCredits
+		}
+
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// permission codes
+const (
+	PermissionMoviesRead  = "movies:read"
+	PermissionMoviesWrite = "movies:write"
+	PermissionUsersAdmin  = "users:admin"
+)
+
+// roles bundle permissions; a user has one role, on top of any
+// permissions granted to them directly
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var RoleSafelist = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// Permissions holds permission codes, like "movies:read"
+type Permissions []string
+
+// reports whether code is one of the permissions
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type PermissionModel struct {
+	DB *sql.DB
+}
+
+// returns the codes of all the permissions the user has, through their
+// role or granted directly
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	query := `
+		SELECT permissions.code
+		FROM permissions
+		WHERE permissions.id IN (
+			SELECT permission_id FROM users_permissions WHERE user_id = $1
+			UNION
+			SELECT roles_permissions.permission_id
+			FROM roles_permissions
+			INNER JOIN users ON users.role = roles_permissions.role
+			WHERE users.id = $1
+		)
+		ORDER BY permissions.code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// grants the user the permissions with the given codes; ones the user
+// already has are left alone
+func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+	query := `
+		INSERT INTO users_permissions (user_id, permission_id)
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
@@ -0,0 +1,280 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+	ErrUnknownUser     = errors.New("unknown user")
+)
+
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Review is a user's rating of a movie, with an optional written review;
+// each user can review a movie once
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Score     int32     `json:"score"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score != 0, "score", "must be provided")
+	v.Check(review.Score >= 1 && review.Score <= 10, "score", "must be between 1 and 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// lists the reviews of a movie
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE movie_id = $1
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// gets a user's review of a movie
+func (m ReviewModel) GetForUser(movieID, userID int64) (*Review, error) {
+	query := `
+		SELECT id, created_at, movie_id, user_id, score, body, version
+		FROM reviews
+		WHERE movie_id = $1 AND user_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Score,
+		&review.Body,
+		&review.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// adds a review to a movie that isn't in the trash, and updates the movie's rating
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, score, body)
+		SELECT id, $2, $3, $4
+		FROM movies
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, created_at, version`
+
+	args := []interface{}{review.MovieID, review.UserID, review.Score, review.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation":
+			return ErrDuplicateReview
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrUnknownUser
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m ReviewModel) Update(review *Review) error {
+	query := `
+		UPDATE reviews
+		SET score = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{review.Score, review.Body, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = updateMovieRating(ctx, tx, review.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deletes a user's review of a movie
+func (m ReviewModel) Delete(movieID, userID int64) error {
+	query := `
+		DELETE FROM reviews
+		WHERE movie_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMovieRating(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, movieID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	err = updateMovieRating(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// locks the movie's row before its reviews change; otherwise two concurrent
+// changes could each recalculate the rating without the other's review,
+// and the last one to commit would leave it wrong
+func lockMovieRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM movies WHERE id = $1 FOR UPDATE`, movieID)
+	return err
+}
+
+// recalculates the rating stored on a movie from its reviews; ratings are
+// kept on the movie row so listings can sort by them, but don't bump its
+// version, as they aren't edits and shouldn't cause edit conflicts. The
+// movie's row must be locked with lockMovieRating first
+func updateMovieRating(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	query := `
+		UPDATE movies
+		SET ratings_count = r.count, average_rating = COALESCE(r.average, 0)
+		FROM (SELECT count(*) AS count, avg(score) AS average FROM reviews WHERE movie_id = $1) AS r
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+	return err
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// actions recorded in a movie's revision history
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionRevert  = "revert"
+	RevisionCredits = "credits" // the movie's cast or crew changed
+	RevisionImages  = "images"  // an image was added to or removed from the movie
+)
+
+type RevisionModel struct {
+	DB *sql.DB
+}
+
+// Revision is a full snapshot of a movie as of one version
+type Revision struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Action    string    `json:"action"`
+	UserID    *int64    `json:"user_id,omitempty"` // who made the change, unless it was anonymous
+	CreatedAt time.Time `json:"created_at"`
+	Movie     Movie     `json:"movie"`
+}
+
+// FieldChange holds the old and new value of one field between two revisions
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// writes a revision holding the movie's current values, made by the user
+// with userID (0 if unknown); it's called within the transaction that
+// changed the movie, so history can't drift from the data
+func insertRevision(ctx context.Context, q querier, movie *Movie, action string, userID int64) error {
+	snapshot, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, action, user_id, snapshot)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	user := sql.NullInt64{Int64: userID, Valid: userID > 0}
+
+	_, err = q.ExecContext(ctx, query, movie.ID, movie.Version, action, user, snapshot)
+	return err
+}
+
+// lists the revisions of a movie
+func (m RevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*Revision, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, movie_id, version, action, user_id, created_at, snapshot
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy(nil))
+
+	// context with 3-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*Revision{}
+
+	for rows.Next() {
+		var revision Revision
+		var snapshot []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Action,
+			&revision.UserID,
+			&revision.CreatedAt,
+			&snapshot,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(snapshot, &revision.Movie)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// gets the revision of a movie at a specific version
+func (m RevisionModel) Get(movieID int64, version int32) (*Revision, error) {
+	query := `
+		SELECT id, movie_id, version, action, user_id, created_at, snapshot
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	var revision Revision
+	var snapshot []byte
+
+	// context with 3-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&revision.UserID,
+		&revision.CreatedAt,
+		&snapshot,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(snapshot, &revision.Movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// returns the fields whose values differ between two movie snapshots
+func DiffMovies(from, to *Movie) []FieldChange {
+	changes := []FieldChange{}
+
+	if from.Title != to.Title {
+		changes = append(changes, FieldChange{Field: "title", From: from.Title, To: to.Title})
+	}
+	if from.Year != to.Year {
+		changes = append(changes, FieldChange{Field: "year", From: from.Year, To: to.Year})
+	}
+	if from.Runtime != to.Runtime {
+		changes = append(changes, FieldChange{Field: "runtime", From: from.Runtime, To: to.Runtime})
+	}
+	if !reflect.DeepEqual(from.Genres, to.Genres) {
+		changes = append(changes, FieldChange{Field: "genres", From: from.Genres, To: to.Genres})
+	}
+
+	return changes
+}
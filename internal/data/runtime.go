@@ -52,3 +52,16 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 
 	return nil
 }
+
+// parses a runtime given either as "<runtime> mins" or as a plain
+// integer number of minutes, as used in query strings
+func ParseRuntime(s string) (Runtime, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), " mins")
+
+	i, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, ErrInvalidRuntimeFormat
+	}
+
+	return Runtime(i), nil
+}
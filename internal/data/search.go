@@ -0,0 +1,124 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+// text search configurations that have a GIN index on movie titles
+var SearchLanguageSafelist = []string{"simple", "english"}
+
+// MovieSearch holds options for searching movie titles
+type MovieSearch struct {
+	Language  string
+	Highlight bool
+	Fuzzy     bool // match titles by trigram similarity instead of full-text search
+}
+
+func ValidateMovieSearch(v *validator.Validator, search MovieSearch) {
+	v.Check(validator.In(search.Language, SearchLanguageSafelist...), "search_language", "invalid search language")
+	v.Check(!search.Fuzzy || !search.Highlight, "highlight", "is not supported for fuzzy searches")
+}
+
+// checks if the search language is safe to write into a query
+func (s MovieSearch) config() string {
+	for _, safeValue := range SearchLanguageSafelist {
+		if s.Language == safeValue {
+			return s.Language
+		}
+	}
+
+	panic("unsafe search language: " + s.Language)
+}
+
+// titleQuery holds the SQL expressions for a title search
+type titleQuery struct {
+	match    string // condition selecting the matching movies
+	rank     string // relevance of a match, higher is better
+	headline string // title with the matching words highlighted
+}
+
+// builds the SQL expressions for searching titles, adding the search terms to args
+func (s MovieSearch) titleQuery(args *queryArgs, title string) titleQuery {
+	q := titleQuery{match: "TRUE", rank: "0::real", headline: "''"}
+
+	if strings.TrimSpace(title) == "" {
+		return q
+	}
+
+	// trigram matching, which tolerates typos like "Godfater";
+	// word_similarity also matches a misspelled part of a longer title
+	if s.Fuzzy {
+		term := args.add(title)
+		q.match = fmt.Sprintf("%s <%% title", term)
+		q.rank = fmt.Sprintf("word_similarity(%s, title)", term)
+		return q
+	}
+
+	// separate prefix terms like "godf*" from the websearch text
+	text, prefixes := splitPrefixTerms(title)
+
+	var parts []string
+	if text != "" {
+		parts = append(parts, fmt.Sprintf("websearch_to_tsquery('%s', %s)", s.config(), args.add(text)))
+	}
+	if prefixes != "" {
+		parts = append(parts, fmt.Sprintf("to_tsquery('%s', %s)", s.config(), args.add(prefixes)))
+	}
+
+	// nothing left to search for, e.g. a lone "*"
+	if len(parts) == 0 {
+		return q
+	}
+
+	// the safelisted search configuration is written into the query,
+	// so the matching GIN index can be used
+	tsquery := "(" + strings.Join(parts, " && ") + ")"
+	q.match = fmt.Sprintf("to_tsvector('%s', title) @@ %s", s.config(), tsquery)
+	q.rank = fmt.Sprintf("ts_rank(to_tsvector('%s', title), %s)", s.config(), tsquery)
+
+	if s.Highlight {
+		q.headline = fmt.Sprintf("ts_headline('%s', title, %s)", s.config(), tsquery)
+	}
+
+	return q
+}
+
+// splits a title search into the text for websearch_to_tsquery and a
+// to_tsquery expression for words ending in '*', which match as prefixes;
+// for example: `"blade runner" -final godf*` becomes `"blade runner" -final`
+// and `godf:*`
+func splitPrefixTerms(title string) (string, string) {
+	var words, prefixes []string
+
+	for _, word := range strings.Fields(title) {
+		if !strings.HasSuffix(word, "*") {
+			words = append(words, word)
+			continue
+		}
+
+		// keep only letters and digits so the term can't break to_tsquery syntax
+		term := strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, word)
+
+		if term == "" {
+			continue
+		}
+
+		// a leading '-' excludes the prefix, as it does for websearch words
+		if strings.HasPrefix(word, "-") {
+			term = "!" + term
+		}
+
+		prefixes = append(prefixes, term+":*")
+	}
+
+	return strings.Join(words, " "), strings.Join(prefixes, " & ")
+}
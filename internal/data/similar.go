@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SimilarityWeights sets how much each signal counts towards how similar
+// two movies are; each signal scores between 0 and 1
+type SimilarityWeights struct {
+	Genre float64 // share of the movie's genres the other movie also has
+	Year  float64 // closeness of release years, 0 at 20 or more years apart
+	Title float64 // trigram similarity of the titles
+}
+
+// SimilarMovie is a movie recommended from another one, with its score
+type SimilarMovie struct {
+	*Movie
+	Score float32 `json:"score"`
+}
+
+// lists the movies most similar to movie, best first; only movies sharing
+// at least one genre are considered, so the genres GIN index can be used
+func (m MovieModel) GetSimilar(movie *Movie, weights SimilarityWeights, filters Filters) ([]*SimilarMovie, Metadata, error) {
+	query := `
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, average_rating, ratings_count, score
+		FROM (
+			SELECT *,
+				$4::float8 * cardinality(ARRAY(SELECT unnest(genres) INTERSECT SELECT unnest($2::text[]))) / greatest(cardinality($2::text[]), 1)
+				+ $5::float8 * greatest(0, 1 - abs(year - $3::integer) / 20.0)::float8
+				+ $6::float8 * similarity(title, $7) AS score
+			FROM movies
+			WHERE id <> $1 AND deleted_at IS NULL AND genres && $2::text[]
+		) AS candidates
+		ORDER BY score DESC, id ASC
+		LIMIT $8 OFFSET $9`
+
+	args := []interface{}{
+		movie.ID,
+		pq.Array(movie.Genres),
+		movie.Year,
+		weights.Genre,
+		weights.Year,
+		weights.Title,
+		movie.Title,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*SimilarMovie{}
+
+	for rows.Next() {
+		similar := SimilarMovie{Movie: &Movie{}}
+
+		err := rows.Scan(
+			&totalRecords,
+			&similar.ID,
+			&similar.CreatedAt,
+			&similar.Title,
+			&similar.Year,
+			&similar.Runtime,
+			pq.Array(&similar.Genres),
+			&similar.Version,
+			&similar.AverageRating,
+			&similar.RatingsCount,
+			&similar.Score,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &similar)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
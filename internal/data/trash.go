@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// lists the movies in the trash
+func (m MovieModel) GetTrashed(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.orderBy(nil))
+
+	// context with 3-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// takes a movie back out of the trash
+func (m MovieModel) Restore(id, userID int64) (*Movie, error) {
+
+	// movie ID cannot be less than 1
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, title, year, runtime, genres, version`
+
+	var movie Movie
+
+	// context with a 3-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = insertRevision(ctx, tx, &movie, RevisionRestore, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// permanently deletes movies that have been in the trash for longer
+// than the retention period, and returns how many were removed along with
+// the storage keys of their images, whose files the caller should delete
+func (m MovieModel) Purge(retention time.Duration) (int64, []string, error) {
+	// context with a 3-second timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	cutoff := time.Now().Add(-retention)
+
+	// image rows go with their movies, so collect their keys first
+	query := `
+		SELECT i.key
+		FROM images AS i
+		JOIN movies AS m ON m.id = i.movie_id
+		WHERE m.deleted_at < $1`
+
+	rows, err := tx.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []string{}
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	rows.Close()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM movies WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return purged, keys, nil
+}
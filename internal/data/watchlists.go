@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.johnboucha.com/internal/validator"
+)
+
+var (
+	ErrDuplicateWatchlistEntry = errors.New("duplicate watchlist entry")
+)
+
+// the lists a movie can be on in a user's watchlist
+var WatchlistSafelist = []string{"want_to_watch", "watched"}
+
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+// WatchlistEntry is a movie on one of a user's lists; a movie is on at most
+// one of them, at a 1-based position that orders the list
+type WatchlistEntry struct {
+	UserID    int64      `json:"-"`
+	MovieID   int64      `json:"movie_id"`
+	List      string     `json:"list"`
+	Position  int32      `json:"position"`
+	AddedAt   time.Time  `json:"added_at"`
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+	Movie     *Movie     `json:"movie,omitempty"`
+}
+
+func ValidateWatchlistEntry(v *validator.Validator, entry *WatchlistEntry) {
+	v.Check(entry.MovieID > 0, "movie_id", "must be provided")
+
+	v.Check(entry.List != "", "list", "must be provided")
+	v.Check(validator.In(entry.List, WatchlistSafelist...), "list", "must be want_to_watch or watched")
+
+	// a zero position puts the movie at the end of the list
+	v.Check(entry.Position >= 0, "position", "must not be negative")
+
+	if entry.WatchedAt != nil {
+		v.Check(entry.List == "watched", "watched_at", "must only be set for watched movies")
+		v.Check(!entry.WatchedAt.After(time.Now()), "watched_at", "must not be in the future")
+	}
+}
+
+// lists the movies on one of a user's lists
+func (m WatchlistModel) GetAll(userID int64, list string, filters Filters) ([]*WatchlistEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), w.user_id, w.movie_id, w.list, w.position, w.added_at, w.watched_at,
+			m.title, m.year, m.runtime, m.genres, m.version
+		FROM watchlist_entries AS w
+		JOIN movies AS m ON m.id = w.movie_id
+		WHERE w.user_id = $1 AND w.list = $2
+		ORDER BY %s, w.movie_id ASC
+		LIMIT $3 OFFSET $4`, filters.orderBy(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, list, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchlistEntry{}
+
+	for rows.Next() {
+		var entry WatchlistEntry
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.UserID,
+			&entry.MovieID,
+			&entry.List,
+			&entry.Position,
+			&entry.AddedAt,
+			&entry.WatchedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.ID = entry.MovieID
+		entry.Movie = &movie
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// gets the entry for a movie in a user's watchlist
+func (m WatchlistModel) Get(userID, movieID int64) (*WatchlistEntry, error) {
+	query := `
+		SELECT user_id, movie_id, list, position, added_at, watched_at
+		FROM watchlist_entries
+		WHERE user_id = $1 AND movie_id = $2`
+
+	var entry WatchlistEntry
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(
+		&entry.UserID,
+		&entry.MovieID,
+		&entry.List,
+		&entry.Position,
+		&entry.AddedAt,
+		&entry.WatchedAt,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// adds a movie that isn't in the trash to a user's list, at entry.Position
+// or at the end of the list
+func (m WatchlistModel) Insert(entry *WatchlistEntry) error {
+	query := `
+		INSERT INTO watchlist_entries (user_id, movie_id, list, position, watched_at)
+		SELECT $1, id, $3, $4, $5
+		FROM movies
+		WHERE id = $2 AND deleted_at IS NULL
+		RETURNING added_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	// watching a movie without saying when means it was just watched
+	if entry.List == "watched" && entry.WatchedAt == nil {
+		now := time.Now()
+		entry.WatchedAt = &now
+	}
+
+	entry.Position, err = openWatchlistPosition(ctx, tx, entry.UserID, entry.MovieID, entry.List, entry.Position)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{entry.UserID, entry.MovieID, entry.List, entry.Position, entry.WatchedAt}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation":
+			return ErrDuplicateWatchlistEntry
+		case errors.As(err, &pqErr) && pqErr.Code.Name() == "foreign_key_violation":
+			return ErrUnknownUser
+		default:
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// moves an entry to entry.List at entry.Position, which reorders it when
+// the list doesn't change; the rest of both lists shift to make room
+func (m WatchlistModel) Update(entry *WatchlistEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, entry.UserID)
+	if err != nil {
+		return err
+	}
+
+	// take the entry out of its current list first
+	err = closeWatchlistPosition(ctx, tx, entry.UserID, entry.MovieID)
+	if err != nil {
+		return err
+	}
+
+	entry.Position, err = openWatchlistPosition(ctx, tx, entry.UserID, entry.MovieID, entry.List, entry.Position)
+	if err != nil {
+		return err
+	}
+
+	// moving off the watched list forgets when the movie was watched
+	if entry.List != "watched" {
+		entry.WatchedAt = nil
+	} else if entry.WatchedAt == nil {
+		now := time.Now()
+		entry.WatchedAt = &now
+	}
+
+	query := `
+		UPDATE watchlist_entries
+		SET list = $1, position = $2, watched_at = $3
+		WHERE user_id = $4 AND movie_id = $5`
+
+	_, err = tx.ExecContext(ctx, query, entry.List, entry.Position, entry.WatchedAt, entry.UserID, entry.MovieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// removes a movie from a user's watchlist
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = closeWatchlistPosition(ctx, tx, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM watchlist_entries WHERE user_id = $1 AND movie_id = $2`, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// locks the user's row before their watchlist changes, so changes to it run
+// one at a time; otherwise two concurrent ones could read the same end of a
+// list and give two entries the same position. FOR NO KEY UPDATE still lets
+// other tables reference the user meanwhile
+func lockWatchlist(ctx context.Context, tx *sql.Tx, userID int64) error {
+	var exists int
+
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID).Scan(&exists)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrUnknownUser
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// makes room at position in a user's list for a movie by shifting the other
+// entries from there on down by one, and returns the position to use; zero
+// or anything past the end of the list means the end
+func openWatchlistPosition(ctx context.Context, tx *sql.Tx, userID, movieID int64, list string, position int32) (int32, error) {
+	var last int32
+
+	// the movie itself may still be on the list when it's being reordered
+	query := `
+		SELECT COALESCE(max(position), 0)
+		FROM watchlist_entries
+		WHERE user_id = $1 AND list = $2 AND movie_id <> $3`
+
+	err := tx.QueryRowContext(ctx, query, userID, list, movieID).Scan(&last)
+	if err != nil {
+		return 0, err
+	}
+
+	if position == 0 || position > last {
+		return last + 1, nil
+	}
+
+	query = `
+		UPDATE watchlist_entries
+		SET position = position + 1
+		WHERE user_id = $1 AND list = $2 AND movie_id <> $3 AND position >= $4`
+
+	_, err = tx.ExecContext(ctx, query, userID, list, movieID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	return position, nil
+}
+
+// closes the gap an entry leaves in its list when it's moved or removed, by
+// shifting the entries after it up by one; the user's watchlist must be
+// locked with lockWatchlist first
+func closeWatchlistPosition(ctx context.Context, tx *sql.Tx, userID, movieID int64) error {
+	var list string
+	var position int32
+
+	query := `
+		SELECT list, position
+		FROM watchlist_entries
+		WHERE user_id = $1 AND movie_id = $2
+		FOR UPDATE`
+
+	err := tx.QueryRowContext(ctx, query, userID, movieID).Scan(&list, &position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	query = `
+		UPDATE watchlist_entries
+		SET position = position - 1
+		WHERE user_id = $1 AND list = $2 AND position > $3`
+
+	_, err = tx.ExecContext(ctx, query, userID, list, position)
+	return err
+}
+
+// removes a movie from every watchlist, closing the gaps it leaves; called
+// when the movie is deleted
+func dropMovieFromWatchlists(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	// lock every affected watchlist as lockWatchlist does, in id order so
+	// two of these can't deadlock
+	lockQuery := `
+		SELECT 1 FROM users
+		WHERE id IN (SELECT user_id FROM watchlist_entries WHERE movie_id = $1)
+		ORDER BY id
+		FOR NO KEY UPDATE`
+
+	_, err := tx.ExecContext(ctx, lockQuery, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		WITH removed AS (
+			DELETE FROM watchlist_entries
+			WHERE movie_id = $1
+			RETURNING user_id, list, position
+		)
+		UPDATE watchlist_entries AS w
+		SET position = w.position - 1
+		FROM removed AS r
+		WHERE w.user_id = r.user_id AND w.list = r.list AND w.position > r.position`
+
+	_, err = tx.ExecContext(ctx, query, movieID)
+	return err
+}
@@ -0,0 +1,418 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// media types for the two patch formats
+const (
+	MergePatchType = "application/merge-patch+json" // RFC 7396
+	JSONPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch document")
+	ErrTestFailed   = errors.New("test operation failed")
+)
+
+// Operation is a single RFC 6902 operation
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"` // empty if absent; a JSON null is kept as "null"
+}
+
+// applies an RFC 7396 merge patch to a JSON document
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := decode(patch)
+	if err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+// merges patch into target; null values remove members, objects merge
+// recursively, and anything else replaces the target value
+func merge(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+
+		t[key] = merge(t[key], value)
+	}
+
+	return t
+}
+
+// applies an RFC 6902 JSON patch to a JSON document; operations are applied
+// in order, and the whole patch fails if any one of them does
+func Apply(doc, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []Operation
+
+	err = decodeInto(patch, &ops)
+	if err != nil {
+		return nil, ErrInvalidPatch
+	}
+
+	for i, op := range ops {
+		target, err = applyOperation(target, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %q): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func applyOperation(doc interface{}, op Operation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, errors.New("value must be provided")
+		}
+
+		value, err := decode(op.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			// replace is a remove followed by an add at the same location
+			doc, _, err = remove(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			return add(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !equal(current, value) {
+				return nil, ErrTestFailed
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		doc, _, err = remove(doc, path)
+		return doc, err
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if op.Op == "move" {
+			doc, value, err = remove(doc, from)
+		} else {
+			value, err = get(doc, from)
+			if err == nil {
+				value, err = clone(value)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return add(doc, path, value)
+	}
+
+	return nil, fmt.Errorf("unsupported operation %q", op.Op)
+}
+
+// splits an RFC 6901 JSON pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// parses an array index token; "-" refers to the position after the last
+// element, which is only valid when adding
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	last := length - 1
+	if allowEnd {
+		last = length
+	}
+
+	if i > last {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+
+	return i, nil
+}
+
+// returns the value at path
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q does not exist", token)
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot reference %q inside a scalar value", token)
+		}
+	}
+
+	return node, nil
+}
+
+// adds value at path and returns the updated node
+func add(node interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("member %q does not exist", token)
+		}
+
+		updated, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = updated
+
+		return n, nil
+
+	case []interface{}:
+		if len(rest) == 0 {
+			i, err := arrayIndex(token, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+
+			return n, nil
+		}
+
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+
+		updated, err := add(n[i], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("cannot add %q inside a scalar value", token)
+}
+
+// removes the value at path, returning the updated node and the removed value
+func remove(node interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("cannot remove the whole document")
+	}
+
+	token, rest := path[0], path[1:]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("member %q does not exist", token)
+		}
+
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+
+		updated, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[token] = updated
+
+		return n, removed, nil
+
+	case []interface{}:
+		i, err := arrayIndex(token, len(n), false)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+
+		updated, removed, err := remove(n[i], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		n[i] = updated
+
+		return n, removed, nil
+	}
+
+	return nil, nil, fmt.Errorf("cannot remove %q inside a scalar value", token)
+}
+
+// compares two decoded JSON values, treating numbers by value so that
+// 1 and 1.0 are equal
+func equal(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, value := range av {
+			other, ok := bv[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// returns a deep copy of a decoded JSON value
+func clone(value interface{}) (interface{}, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(js)
+}
+
+// decodes JSON keeping numbers as json.Number, so they survive a round trip unchanged
+func decode(js []byte) (interface{}, error) {
+	var value interface{}
+
+	err := decodeInto(js, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// decodes a single JSON value into dst, rejecting anything after it
+func decodeInto(js []byte, dst interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON value")
+	}
+
+	return nil
+}
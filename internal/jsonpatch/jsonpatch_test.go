@@ -0,0 +1,256 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// re-encodes a JSON document so documents can be compared as strings,
+// whatever their key order or whitespace
+func normalize(t *testing.T, js string) string {
+	t.Helper()
+
+	value, err := decode([]byte(js))
+	if err != nil {
+		t.Fatalf("decoding %s: %v", js, err)
+	}
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestMergePatch(t *testing.T) {
+	// the examples from RFC 7396, appendix A, plus invalid patches
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{name: "replace member", doc: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "add member", doc: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{name: "remove member", doc: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{name: "remove one of two", doc: `{"a":"b","b":"c"}`, patch: `{"a":null}`, want: `{"b":"c"}`},
+		{name: "replace array", doc: `{"a":["b"]}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "array replaces value", doc: `{"a":"c"}`, patch: `{"a":["b"]}`, want: `{"a":["b"]}`},
+		{name: "nested merge", doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{name: "arrays aren't merged", doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{name: "non-object patch", doc: `["a","b"]`, patch: `["c","d"]`, want: `["c","d"]`},
+		{name: "object replaces scalar", doc: `{"a":"foo"}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+		{name: "null patch", doc: `{"a":"foo"}`, patch: `null`, want: `null`},
+		{name: "large numbers survive", doc: `{"a":9007199254740993}`, patch: `{"b":1.50}`, want: `{"a":9007199254740993,"b":1.50}`},
+		{name: "malformed", doc: `{"a":"b"}`, patch: `{"a":`, wantErr: ErrInvalidPatch},
+		{name: "trailing data", doc: `{"a":"b"}`, patch: `{"a":"c"} trailing`, wantErr: ErrInvalidPatch},
+		{name: "second value", doc: `{"a":"b"}`, patch: `{"a":"c"}{"a":"d"}`, wantErr: ErrInvalidPatch},
+		{name: "trailing whitespace", doc: `{"a":"b"}`, patch: "{\"a\":\"c\"}\n", want: `{"a":"c"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && string(got) != normalize(t, tt.want) {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	// mostly the examples from RFC 6902, appendix A
+	tests := []struct {
+		name     string
+		doc      string
+		patch    string
+		want     string
+		wantFail bool
+		wantErr  error // the error expected when wantFail is set, or nil if any will do
+	}{
+		{
+			name:  "add member",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":"qux"}]`,
+			want:  `{"baz":"qux","foo":"bar"}`,
+		},
+		{
+			name:  "add array element",
+			doc:   `{"foo":["bar","baz"]}`,
+			patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			want:  `{"foo":["bar","qux","baz"]}`,
+		},
+		{
+			name:  "add to end of array",
+			doc:   `{"foo":["bar"]}`,
+			patch: `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			want:  `{"foo":["bar",["abc","def"]]}`,
+		},
+		{
+			name:  "add null value",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"/baz","value":null}]`,
+			want:  `{"baz":null,"foo":"bar"}`,
+		},
+		{
+			name:  "add replaces whole document",
+			doc:   `{"foo":"bar"}`,
+			patch: `[{"op":"add","path":"","value":[1]}]`,
+			want:  `[1]`,
+		},
+		{
+			name:  "remove member",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"remove","path":"/baz"}]`,
+			want:  `{"foo":"bar"}`,
+		},
+		{
+			name:  "remove array element",
+			doc:   `{"foo":["bar","qux","baz"]}`,
+			patch: `[{"op":"remove","path":"/foo/1"}]`,
+			want:  `{"foo":["bar","baz"]}`,
+		},
+		{
+			name:  "replace value",
+			doc:   `{"baz":"qux","foo":"bar"}`,
+			patch: `[{"op":"replace","path":"/baz","value":"boo"}]`,
+			want:  `{"baz":"boo","foo":"bar"}`,
+		},
+		{
+			name:  "move value",
+			doc:   `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			want:  `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`,
+		},
+		{
+			name:  "move array element",
+			doc:   `{"foo":["all","grass","cows","eat"]}`,
+			patch: `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			want:  `{"foo":["all","cows","eat","grass"]}`,
+		},
+		{
+			name:  "copy is independent of its source",
+			doc:   `{"a":{"b":1}}`,
+			patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/a/b","value":2}]`,
+			want:  `{"a":{"b":2},"c":{"b":1}}`,
+		},
+		{
+			name:  "escaped pointer tokens",
+			doc:   `{"/":9,"~1":10}`,
+			patch: `[{"op":"test","path":"/~01","value":10},{"op":"replace","path":"/~1","value":8}]`,
+			want:  `{"/":8,"~1":10}`,
+		},
+		{
+			name:  "test passes",
+			doc:   `{"baz":"qux","foo":["a",2,"c"]}`,
+			patch: `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2.0}]`,
+			want:  `{"baz":"qux","foo":["a",2,"c"]}`,
+		},
+		{
+			name:  "test null passes",
+			doc:   `{"foo":null}`,
+			patch: `[{"op":"test","path":"/foo","value":null}]`,
+			want:  `{"foo":null}`,
+		},
+		{
+			name:     "test fails",
+			doc:      `{"baz":"qux"}`,
+			patch:    `[{"op":"test","path":"/baz","value":"bar"}]`,
+			wantErr:  ErrTestFailed,
+			wantFail: true,
+		},
+		{
+			name:     "a later failure fails the whole patch",
+			doc:      `{"baz":"qux"}`,
+			patch:    `[{"op":"add","path":"/a","value":1},{"op":"test","path":"/baz","value":"bar"}]`,
+			wantErr:  ErrTestFailed,
+			wantFail: true,
+		},
+		{
+			name:     "add to nonexistent target",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "array index out of range",
+			doc:      `{"foo":["bar"]}`,
+			patch:    `[{"op":"add","path":"/foo/2","value":"qux"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "array index with leading zero",
+			doc:      `{"foo":["bar","baz"]}`,
+			patch:    `[{"op":"remove","path":"/foo/01"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "remove missing member",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"remove","path":"/baz"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "missing value",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"add","path":"/baz"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "path without leading slash",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"remove","path":"foo"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "unsupported operation",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"frobnicate","path":"/foo"}]`,
+			wantFail: true,
+		},
+		{
+			name:     "not an array",
+			doc:      `{"foo":"bar"}`,
+			patch:    `{"op":"remove","path":"/foo"}`,
+			wantErr:  ErrInvalidPatch,
+			wantFail: true,
+		},
+		{
+			name:     "trailing data",
+			doc:      `{"foo":"bar"}`,
+			patch:    `[{"op":"remove","path":"/foo"}] trailing`,
+			wantErr:  ErrInvalidPatch,
+			wantFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+
+			if tt.wantFail {
+				if err == nil {
+					t.Fatalf("got %s; want an error", got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if string(got) != normalize(t, tt.want) {
+				t.Errorf("got %s; want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package jwt signs and verifies JSON Web Tokens (RFC 7519) using HS256
+// or Ed25519 keys.
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrUnknownKey       = errors.New("jwt: unknown signing key")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token has expired")
+	ErrNotYetValid      = errors.New("jwt: token is not valid yet")
+	ErrInvalidClaims    = errors.New("jwt: invalid issuer or audience")
+)
+
+var encoding = base64.RawURLEncoding
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Claims are the registered claims we use, plus the user's permissions
+// and activation status. Times are in seconds since the Unix epoch
+type Claims struct {
+	Issuer      string   `json:"iss"`
+	Subject     string   `json:"sub"`
+	Audience    Audience `json:"aud"`
+	Expiry      int64    `json:"exp"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+	IssuedAt    int64    `json:"iat,omitempty"`
+	Activated   bool     `json:"activated"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// Audience is the "aud" claim, which may be a single string or an array
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// Sign returns claims as a compact serialized token signed with key
+func Sign(claims Claims, key *Key) (string, error) {
+	h, err := json.Marshal(header{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+
+	signature, err := key.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verifier checks tokens against a set of keys, picked by the token's
+// "kid" header, so tokens signed with an old key keep working while keys
+// are rotated
+type Verifier struct {
+	Keys     map[string]*Key
+	Issuer   string
+	Audience string
+	Leeway   time.Duration // allowed clock skew for exp and nbf
+}
+
+// Verify checks the token's signature and claims, and returns the claims
+// if it's valid at time now
+func (v *Verifier) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+
+	key, ok := v.Keys[h.KeyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// the algorithm comes from the key, never just from the token, so a
+	// token can't pick a weaker algorithm (or "none") for itself
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidSignature
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if claims.Expiry == 0 || !now.Before(time.Unix(claims.Expiry, 0).Add(v.Leeway)) {
+		return nil, ErrExpired
+	}
+
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-v.Leeway)) {
+		return nil, ErrNotYetValid
+	}
+
+	if claims.Issuer != v.Issuer || !claims.Audience.contains(v.Audience) {
+		return nil, ErrInvalidClaims
+	}
+
+	return &claims, nil
+}
+
+func (a Audience) contains(audience string) bool {
+	for _, aud := range a {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	b, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,369 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func writeKeyFile(t *testing.T, name string, contents []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, contents, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadKey(t *testing.T, spec string) *Key {
+	t.Helper()
+
+	key, err := ParseKeySpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// returns an HS256 key, an Ed25519 signing key and a verify-only copy of
+// the same Ed25519 key, all loaded from files
+func testKeys(t *testing.T) (hs, ed, edPublic *Key) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := writeKeyFile(t, "secret", []byte(strings.Repeat("s", 32)+"\n"))
+	privatePEM := writeKeyFile(t, "ed.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	publicPEM := writeKeyFile(t, "ed.pub.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+	return loadKey(t, "hs:HS256:"+secret), loadKey(t, "ed:EdDSA:"+privatePEM), loadKey(t, "ed:EdDSA:"+publicPEM)
+}
+
+func validClaims() Claims {
+	return Claims{
+		Issuer:    "greenlight",
+		Subject:   "42",
+		Audience:  Audience{"greenlight-api"},
+		Expiry:    now.Add(time.Hour).Unix(),
+		IssuedAt:  now.Unix(),
+		Activated: true,
+	}
+}
+
+func sign(t *testing.T, claims Claims, key *Key) string {
+	t.Helper()
+
+	token, err := Sign(claims, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// builds a token by hand, so the header can say things Sign never would
+func forge(t *testing.T, h header, claims Claims, signFunc func(signingInput []byte) []byte) string {
+	t.Helper()
+
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := encoding.EncodeToString(hb) + "." + encoding.EncodeToString(cb)
+	return signingInput + "." + encoding.EncodeToString(signFunc([]byte(signingInput)))
+}
+
+func hmacWith(secret []byte) func([]byte) []byte {
+	return func(signingInput []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	hs, ed, edPublic := testKeys(t)
+
+	verifier := func(leeway time.Duration, keys ...*Key) *Verifier {
+		v := &Verifier{
+			Keys:     make(map[string]*Key),
+			Issuer:   "greenlight",
+			Audience: "greenlight-api",
+			Leeway:   leeway,
+		}
+		for _, key := range keys {
+			v.Keys[key.ID] = key
+		}
+		return v
+	}
+
+	withClaims := func(change func(*Claims)) Claims {
+		claims := validClaims()
+		change(&claims)
+		return claims
+	}
+
+	tampered := func() string {
+		parts := strings.Split(sign(t, validClaims(), hs), ".")
+		cb, err := json.Marshal(withClaims(func(c *Claims) { c.Permissions = []string{"movies:write"} }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[1] = encoding.EncodeToString(cb)
+		return strings.Join(parts, ".")
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		verifier *Verifier
+		wantErr  error
+	}{
+		{
+			name:     "valid HS256",
+			token:    sign(t, validClaims(), hs),
+			verifier: verifier(0, hs),
+		},
+		{
+			name:     "valid EdDSA",
+			token:    sign(t, validClaims(), ed),
+			verifier: verifier(0, ed),
+		},
+		{
+			name:     "verify-only Ed25519 key",
+			token:    sign(t, validClaims(), ed),
+			verifier: verifier(0, edPublic),
+		},
+		{
+			name:     "audience array",
+			token:    sign(t, withClaims(func(c *Claims) { c.Audience = Audience{"other", "greenlight-api"} }), hs),
+			verifier: verifier(0, hs),
+		},
+		{
+			name:     "malformed",
+			token:    "not.a-token",
+			verifier: verifier(0, hs),
+			wantErr:  ErrMalformed,
+		},
+		{
+			name:     "tampered payload",
+			token:    tampered(),
+			verifier: verifier(0, hs),
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "signed with another key of the same id",
+			token:    forge(t, header{Algorithm: HS256, KeyID: "hs"}, validClaims(), hmacWith([]byte(strings.Repeat("x", 32)))),
+			verifier: verifier(0, hs),
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "alg none",
+			token:    forge(t, header{Algorithm: "none", KeyID: "hs"}, validClaims(), func([]byte) []byte { return nil }),
+			verifier: verifier(0, hs),
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "alg mismatch",
+			token:    forge(t, header{Algorithm: HS256, KeyID: "ed"}, validClaims(), hmacWith(edPublic.publicKey)),
+			verifier: verifier(0, edPublic),
+			wantErr:  ErrInvalidSignature,
+		},
+		{
+			name:     "unknown kid",
+			token:    sign(t, validClaims(), hs),
+			verifier: verifier(0, ed),
+			wantErr:  ErrUnknownKey,
+		},
+		{
+			name:     "empty kid",
+			token:    forge(t, header{Algorithm: HS256}, validClaims(), hmacWith(hs.secret)),
+			verifier: verifier(0, hs),
+			wantErr:  ErrUnknownKey,
+		},
+		{
+			name:     "no expiry",
+			token:    sign(t, withClaims(func(c *Claims) { c.Expiry = 0 }), hs),
+			verifier: verifier(0, hs),
+			wantErr:  ErrExpired,
+		},
+		{
+			name:     "expired",
+			token:    sign(t, withClaims(func(c *Claims) { c.Expiry = now.Add(-time.Minute).Unix() }), hs),
+			verifier: verifier(0, hs),
+			wantErr:  ErrExpired,
+		},
+		{
+			name:     "expired within leeway",
+			token:    sign(t, withClaims(func(c *Claims) { c.Expiry = now.Add(-time.Minute).Unix() }), hs),
+			verifier: verifier(2*time.Minute, hs),
+		},
+		{
+			name:     "expired beyond leeway",
+			token:    sign(t, withClaims(func(c *Claims) { c.Expiry = now.Add(-3 * time.Minute).Unix() }), hs),
+			verifier: verifier(2*time.Minute, hs),
+			wantErr:  ErrExpired,
+		},
+		{
+			name:     "not valid yet",
+			token:    sign(t, withClaims(func(c *Claims) { c.NotBefore = now.Add(time.Minute).Unix() }), hs),
+			verifier: verifier(0, hs),
+			wantErr:  ErrNotYetValid,
+		},
+		{
+			name:     "not valid yet within leeway",
+			token:    sign(t, withClaims(func(c *Claims) { c.NotBefore = now.Add(time.Minute).Unix() }), hs),
+			verifier: verifier(2*time.Minute, hs),
+		},
+		{
+			name:     "not valid yet beyond leeway",
+			token:    sign(t, withClaims(func(c *Claims) { c.NotBefore = now.Add(3 * time.Minute).Unix() }), hs),
+			verifier: verifier(2*time.Minute, hs),
+			wantErr:  ErrNotYetValid,
+		},
+		{
+			name:     "wrong issuer",
+			token:    sign(t, withClaims(func(c *Claims) { c.Issuer = "someone-else" }), hs),
+			verifier: verifier(0, hs),
+			wantErr:  ErrInvalidClaims,
+		},
+		{
+			name:     "wrong audience",
+			token:    sign(t, withClaims(func(c *Claims) { c.Audience = Audience{"another-api"} }), hs),
+			verifier: verifier(0, hs),
+			wantErr:  ErrInvalidClaims,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.verifier.Verify(tt.token, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v; want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && claims.Subject != "42" {
+				t.Errorf("got subject %q; want %q", claims.Subject, "42")
+			}
+		})
+	}
+}
+
+func TestVerifyOnlyKeyCantSign(t *testing.T) {
+	hs, ed, edPublic := testKeys(t)
+
+	tests := []struct {
+		name    string
+		key     *Key
+		canSign bool
+	}{
+		{name: "HS256", key: hs, canSign: true},
+		{name: "Ed25519 private key", key: ed, canSign: true},
+		{name: "Ed25519 public key", key: edPublic, canSign: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.key.CanSign(); got != tt.canSign {
+				t.Errorf("got CanSign %v; want %v", got, tt.canSign)
+			}
+
+			_, err := Sign(validClaims(), tt.key)
+			if (err == nil) != tt.canSign {
+				t.Errorf("got Sign error %v; want signing allowed %v", err, tt.canSign)
+			}
+		})
+	}
+}
+
+func TestParseKeySpec(t *testing.T) {
+	short := writeKeyFile(t, "short", []byte("too short"))
+	secret := writeKeyFile(t, "secret", []byte(strings.Repeat("s", 32)))
+	notPEM := writeKeyFile(t, "not.pem", []byte("not PEM data"))
+
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "valid", spec: "hs:HS256:" + secret},
+		{name: "missing kid", spec: ":HS256:" + secret, wantErr: true},
+		{name: "missing path", spec: "hs:HS256:", wantErr: true},
+		{name: "too few parts", spec: "hs:" + secret, wantErr: true},
+		{name: "short secret", spec: "hs:HS256:" + short, wantErr: true},
+		{name: "unsupported algorithm", spec: "hs:RS256:" + secret, wantErr: true},
+		{name: "no PEM data", spec: "ed:EdDSA:" + notPEM, wantErr: true},
+		{name: "missing file", spec: "hs:HS256:" + filepath.Join(t.TempDir(), "missing"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKeySpec(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v; want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAudienceJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience Audience
+		json     string
+	}{
+		{name: "single", audience: Audience{"a"}, json: `"a"`},
+		{name: "multiple", audience: Audience{"a", "b"}, json: `["a","b"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.audience)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.json {
+				t.Errorf("got %s; want %s", b, tt.json)
+			}
+
+			var got Audience
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.audience, ",") {
+				t.Errorf("got %v; want %v", got, tt.audience)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// supported signing algorithms
+const (
+	HS256 = "HS256"
+	EdDSA = "EdDSA"
+)
+
+// Key is a signing or verification key, identified by the "kid" header
+// of the tokens it signs
+type Key struct {
+	ID        string
+	Algorithm string
+
+	secret     []byte             // HS256
+	privateKey ed25519.PrivateKey // EdDSA, only needed for signing
+	publicKey  ed25519.PublicKey  // EdDSA
+}
+
+// loads a key from a file: a raw shared secret of at least 32 bytes for
+// HS256, or a PEM encoded PKCS #8 private key or PKIX public key for EdDSA
+func LoadKey(id, algorithm, path string) (*Key, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id, Algorithm: algorithm}
+
+	switch algorithm {
+	case HS256:
+		key.secret = bytes.TrimSpace(contents)
+		if len(key.secret) < 32 {
+			return nil, fmt.Errorf("jwt: key %q: HS256 secret must be at least 32 bytes", id)
+		}
+	case EdDSA:
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("jwt: key %q: no PEM data found", id)
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: key %q: %w", id, err)
+			}
+
+			privateKey, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt: key %q: not an Ed25519 private key", id)
+			}
+
+			key.privateKey = privateKey
+			key.publicKey = privateKey.Public().(ed25519.PublicKey)
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("jwt: key %q: %w", id, err)
+			}
+
+			publicKey, ok := parsed.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt: key %q: not an Ed25519 public key", id)
+			}
+
+			key.publicKey = publicKey
+		default:
+			return nil, fmt.Errorf("jwt: key %q: unexpected PEM block %q", id, block.Type)
+		}
+	default:
+		return nil, fmt.Errorf("jwt: key %q: unsupported algorithm %q", id, algorithm)
+	}
+
+	return key, nil
+}
+
+// parses a "kid:algorithm:path" key spec, as used by command line flags,
+// and loads the key
+func ParseKeySpec(spec string) (*Key, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("jwt: key spec %q must have the form kid:algorithm:path", spec)
+	}
+
+	return LoadKey(parts[0], parts[1], parts[2])
+}
+
+// reports whether the key can sign tokens, not just verify them
+func (k *Key) CanSign() bool {
+	return k.secret != nil || k.privateKey != nil
+}
+
+func (k *Key) sign(signingInput []byte) ([]byte, error) {
+	switch {
+	case k.Algorithm == HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+		return mac.Sum(nil), nil
+	case k.Algorithm == EdDSA && k.privateKey != nil:
+		return ed25519.Sign(k.privateKey, signingInput), nil
+	default:
+		return nil, errors.New("jwt: key can't sign tokens")
+	}
+}
+
+func (k *Key) verify(signingInput, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(signingInput)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case EdDSA:
+		return ed25519.Verify(k.publicKey, signingInput, signature)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// anything that isn't safe in a file name
+var unsafeFileRX = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// File writes emails to .eml files in a directory instead of sending them,
+// for local development
+type File struct {
+	Dir    string
+	Sender string
+}
+
+func (m *File) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(m.Sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileRX.ReplaceAllString(recipient, "_"))
+
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0o644)
+}
+
+// Memory keeps sent emails in memory, so tests can check what was sent
+type Memory struct {
+	Sender string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Memory) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(m.Sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, *msg)
+
+	return nil
+}
+
+// returns the emails sent so far
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message{}, m.messages...)
+}
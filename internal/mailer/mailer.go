@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	ttemplate "text/template"
+	"time"
+)
+
+// email templates, each defining "subject", "plainBody" and "htmlBody"
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends emails rendered from one of the templates in templates/
+type Mailer interface {
+	Send(recipient, templateFile string, data interface{}) error
+}
+
+// Message is a rendered email
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// renders the templates in templateFile with data into a message
+func render(sender, recipient, templateFile string, data interface{}) (*Message, error) {
+	msg := &Message{From: sender, To: recipient}
+
+	// subject and plain text body are rendered as text, so nothing gets HTML-escaped
+	tmpl, err := ttemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+	msg.Subject = strings.TrimSpace(subject.String())
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+	msg.PlainBody = plainBody.String()
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+	msg.HTMLBody = htmlBody.String()
+
+	return msg, nil
+}
+
+// returns the message in MIME format, with plain text and HTML alternatives
+func (msg *Message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+
+	headers := []string{
+		"From: " + msg.From,
+		"To: " + msg.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + w.Boundary(),
+	}
+
+	buf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		_, err = qw.Write([]byte(part.body))
+		if err != nil {
+			return nil, err
+		}
+
+		err = qw.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
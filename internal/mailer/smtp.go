@@ -0,0 +1,127 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTP sends emails through an SMTP server
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   string // the From header, e.g. "Greenlight <no-reply@example.com>"
+	envelope string // the bare address given to MAIL FROM
+}
+
+func NewSMTP(host string, port int, username, password, sender string) (*SMTP, error) {
+	// MAIL FROM only takes the address, not the display name around it
+	addr, err := mail.ParseAddress(sender)
+	if err != nil {
+		return nil, fmt.Errorf("mailer: invalid sender %q: %w", sender, err)
+	}
+
+	return &SMTP{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		sender:   sender,
+		envelope: addr.Address,
+	}, nil
+}
+
+// renders and sends an email, retrying a couple of times on failure
+func (m *SMTP) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i <= 3; i++ {
+		err = m.send(recipient, body)
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return err
+}
+
+// delivers one message; net/smtp has no timeouts of its own, so the
+// connection gets a deadline covering the whole exchange
+func (m *SMTP) send(recipient string, body []byte) error {
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	err = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.username != "" {
+		err = c.Auth(smtp.PlainAuth("", m.username, m.password, m.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.envelope)
+	if err != nil {
+		return err
+	}
+
+	err = c.Rcpt(recipient)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on disk; it also serves them, for when
+// the API itself hosts the files at BaseURL
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+// returns the path of the file for key; cleaning the key as an absolute
+// path first means it can't point outside of the directory
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (l *Local) Save(ctx context.Context, key string, r io.Reader) error {
+	name := l.path(key)
+
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file that's renamed once complete, so a failed
+	// upload never leaves a partial file behind under the key
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return strings.TrimSuffix(l.BaseURL, "/") + "/" + strings.TrimPrefix(key, "/")
+}
+
+// serves the file whose key is the request path, so mount it with the
+// BaseURL prefix stripped; directories aren't listed
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, err := os.Open(l.path(r.URL.Path))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// keys are never reused, so a file never changes
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Storage stores uploaded files under a key, e.g. "movies/1/3f2a.jpg", and
+// tells clients where to get them
+type Storage interface {
+	// writes the contents of r under key, replacing anything already there
+	Save(ctx context.Context, key string, r io.Reader) error
+	// removes the file stored under key; removing a missing file isn't an error
+	Delete(ctx context.Context, key string) error
+	// returns the URL clients can fetch the file stored under key from
+	URL(key string) string
+}
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    action text NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    snapshot jsonb NOT NULL,
+    UNIQUE (movie_id, version)
+);
+
+-- record the current state of existing movies as their first known revision
+INSERT INTO movie_revisions (movie_id, version, action, snapshot)
+SELECT id, version, 'create', jsonb_build_object(
+    'id', id,
+    'title', title,
+    'year', year,
+    'runtime', runtime || ' mins',
+    'genres', genres,
+    'version', version
+)
+FROM movies
+ON CONFLICT DO NOTHING;
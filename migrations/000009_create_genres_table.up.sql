@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name citext UNIQUE NOT NULL,
+    slug text UNIQUE NOT NULL,
+    aliases text[] NOT NULL DEFAULT '{}',
+    version integer NOT NULL DEFAULT 1
+);
+
+-- one genre per distinct slug among the existing values, named after its
+-- most used spelling; spellings with different slugs, like "Sci-Fi" and
+-- "Science Fiction", are left for an admin to merge
+INSERT INTO genres (name, slug)
+SELECT DISTINCT ON (slug) value, slug
+FROM (
+    SELECT value,
+        trim(both '-' FROM regexp_replace(lower(value), '[^[:alnum:]]+', '-', 'g')) AS slug,
+        count(*) AS uses
+    FROM movies, unnest(genres) AS value
+    GROUP BY value
+) AS spellings
+WHERE slug <> ''
+ORDER BY slug, uses DESC, value
+ON CONFLICT DO NOTHING;
+
+-- rewrite movie genres to the canonical names, keeping their order and
+-- dropping duplicates that now share a name
+UPDATE movies
+SET genres = ARRAY(
+    SELECT g.name::text
+    FROM unnest(movies.genres) WITH ORDINALITY AS value(value, position)
+    JOIN genres AS g ON g.slug = trim(both '-' FROM regexp_replace(lower(value.value), '[^[:alnum:]]+', '-', 'g'))
+    GROUP BY g.name
+    ORDER BY min(value.position)
+);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS movies_ratings_count_idx;
+DROP INDEX IF EXISTS movies_average_rating_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS ratings_count;
+ALTER TABLE movies DROP COLUMN IF EXISTS average_rating;
+
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    score integer NOT NULL CHECK (score BETWEEN 1 AND 10),
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (movie_id, user_id)
+);
+
+-- aggregates kept up to date by ReviewModel, so listings can sort on them
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating real NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS ratings_count integer NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS movies_ratings_count_idx ON movies (ratings_count, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,14 @@
+-- positions are kept contiguous by WatchlistModel rather than a unique
+-- constraint, as shifting a list would trip it part way through an update
+CREATE TABLE IF NOT EXISTS watchlist_entries (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    list text NOT NULL CHECK (list IN ('want_to_watch', 'watched')),
+    position integer NOT NULL CHECK (position > 0),
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    watched_at timestamp(0) with time zone,
+    PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS watchlist_entries_list_idx ON watchlist_entries (user_id, list, position);
+CREATE INDEX IF NOT EXISTS watchlist_entries_movie_id_idx ON watchlist_entries (movie_id);
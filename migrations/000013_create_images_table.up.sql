@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS images (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    kind text NOT NULL CHECK (kind IN ('poster', 'still')),
+    key text UNIQUE NOT NULL,
+    content_type text NOT NULL,
+    size bigint NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS images_movie_id_idx ON images (movie_id);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('movies:read'),
+    ('movies:write')
+ON CONFLICT DO NOTHING;
+
+-- existing users keep the read access everyone had before
+INSERT INTO users_permissions (user_id, permission_id)
+SELECT users.id, permissions.id FROM users, permissions
+WHERE permissions.code = 'movies:read'
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,6 @@
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+
+DROP TABLE IF EXISTS roles_permissions;
+DROP TABLE IF EXISTS roles;
+
+DELETE FROM permissions WHERE code = 'users:admin';
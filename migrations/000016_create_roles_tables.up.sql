@@ -0,0 +1,36 @@
+INSERT INTO permissions (code)
+VALUES ('users:admin')
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS roles (
+    name text PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role text NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role, permission_id)
+);
+
+INSERT INTO roles (name)
+VALUES
+    ('viewer'),
+    ('editor'),
+    ('admin')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions (role, permission_id)
+SELECT grants.role, permissions.id
+FROM (VALUES
+    ('viewer', 'movies:read'),
+    ('editor', 'movies:read'),
+    ('editor', 'movies:write'),
+    ('admin', 'movies:read'),
+    ('admin', 'movies:write'),
+    ('admin', 'users:admin')
+) AS grants (role, code)
+INNER JOIN permissions ON permissions.code = grants.code
+ON CONFLICT DO NOTHING;
+
+-- everyone starts out as a viewer; the first admin has to be made by hand
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'viewer' REFERENCES roles;
@@ -0,0 +1,2 @@
+-- signed tokens (JWTs) issued before this are no longer accepted for the user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_revoked_at timestamp with time zone;